@@ -11,10 +11,122 @@ import (
 type Config struct {
 	ClickhouseDSN string `json:"clickhouseDSN"`
 	DbName        string `json:"dbName"`
+	// Source selects where transactions are read from: "gcs" (default), "local" or "s3"
+	Source        string `json:"source"`
 	BucketKeyPath string `json:"bucketKeyPath"`
 	BucketName    string `json:"bucketName"`
-	ObjectName    string `json:"objectName"`
-	CoinGeckoAPI  string `json:"coinGeckoAPI"`
+	// ObjectName is a single object, a prefix ending in "/" or a glob pattern such as exports/2024-*.csv
+	ObjectName string `json:"objectName"`
+	// ExtractWorkers is the number of GCS objects read in parallel
+	ExtractWorkers int    `json:"extractWorkers"`
+	LocalPath      string `json:"localPath"`
+	S3Endpoint     string `json:"s3Endpoint"`
+	S3Region       string `json:"s3Region"`
+	S3AccessKey    string `json:"s3AccessKey"`
+	S3SecretKey    string `json:"s3SecretKey"`
+	CoinGeckoAPI   string `json:"coinGeckoAPI"`
+	// CoinGeckoPlan is the plan tier of CoinGeckoAPI deciding the endpoint and the rate limit:
+	// "public", "demo", "analyst", "lite" or "pro". Defaults to "demo" with an API key and "public" without one.
+	CoinGeckoPlan string `json:"coinGeckoPlan"`
+	// CoinGeckoRequestsPerMinute overrides the rate limit of the plan when positive
+	CoinGeckoRequestsPerMinute int `json:"coinGeckoRequestsPerMinute"`
+	// CoinGeckoWorkers is the number of prices fetched in parallel
+	CoinGeckoWorkers int `json:"coinGeckoWorkers"`
+	// CoinGeckoMaxRetries is the number of times a throttled or failed request is retried
+	CoinGeckoMaxRetries int `json:"coinGeckoMaxRetries"`
+	// CoinGeckoRankByMarketCap ranks the coins sharing a symbol by market cap, which costs an extra request per run
+	CoinGeckoRankByMarketCap bool `json:"coinGeckoRankByMarketCap"`
+	// ChainPlatforms maps the chain names of the export onto CoinGecko asset platform IDs, e.g. "polygon" -> "polygon-pos"
+	ChainPlatforms map[string]string `json:"chainPlatforms"`
+	// TokenListDir holds the versions of the CoinGecko token list downloaded by "tokens refresh",
+	// the latest one replaces the bundled coingecko_token_api_list.csv
+	TokenListDir string `json:"tokenListDir"`
+	// CoinIDs pins ambiguous currency symbols to CoinGecko coin IDs
+	CoinIDs CoinIDsConfig `json:"coinIds"`
+	// Pegs prices stablecoins at a fixed USD price and wrapped assets by their underlying coin, keyed by symbol
+	Pegs map[string]PegConfig `json:"pegs"`
+	// DepegThreshold is the relative deviation from the peg at which a sampled stablecoin price is reported,
+	// e.g. 0.02. No price is sampled when zero.
+	DepegThreshold float64 `json:"depegThreshold"`
+	// DepegSamples is the number of days the real price of every stablecoin is sampled on, one when zero
+	DepegSamples int `json:"depegSamples"`
+	// UnknownSymbolPolicy decides what happens to symbols missing from the CoinGecko token list:
+	// "fail" (default), "skip" or "fallback" to the next price providers
+	UnknownSymbolPolicy string `json:"unknownSymbolPolicy"`
+	// PriceProviders is the fallback chain of price providers asked in order: "coingecko", "file" or "binance".
+	// Defaults to CoinGecko only.
+	PriceProviders []string `json:"priceProviders"`
+	// PriceFilePath is the CSV or JSON file of static prices served by the "file" provider
+	PriceFilePath string `json:"priceFilePath"`
+	// PriceOverridesPath is the CSV or JSON file of prices overridden by hand, applied ahead of every provider
+	PriceOverridesPath string `json:"priceOverridesPath"`
+	// PriceSanity flags prices which moved too far from the previous day or from a reference provider
+	PriceSanity PriceSanityConfig `json:"priceSanity"`
+	// BinanceQuoteAsset is the USD stablecoin currencies are paired with by the "binance" provider, USDT when empty
+	BinanceQuoteAsset string `json:"binanceQuoteAsset"`
+	// PriceCachePath is the file historical prices are cached in across runs, no caching when empty
+	PriceCachePath string `json:"priceCachePath"`
+	// Format of the export: "csv", "ndjson" or "parquet", detected from the file extension when empty
+	Format string `json:"format"`
+	// TimestampLayouts are tried in order to parse the ts column: Go time layouts, "rfc3339", "unix" or "unixmilli"
+	TimestampLayouts []string `json:"timestampLayouts"`
+	// SourceTimeZone is the IANA time zone of timestamps that do not carry one, UTC when empty
+	SourceTimeZone string `json:"sourceTimeZone"`
+	// ReportingTimeZone is the IANA time zone whose calendar days the aggregates are bucketed by, UTC when empty
+	ReportingTimeZone string `json:"reportingTimeZone"`
+	// FiatCurrencies are the fiat currencies the volume is reported in besides USD, e.g. ["eur", "gbp"]
+	FiatCurrencies []string `json:"fiatCurrencies"`
+	// PriceGranularity is the resolution transactions are priced at: "daily" (default), "hourly" or "minute"
+	PriceGranularity string `json:"priceGranularity"`
+	// Columns maps the expected column names (ts, project_id, props, nums) onto the header names of the export
+	Columns map[string]string `json:"columns"`
+	// Fields declares where the transaction fields are found in the JSON columns of the export
+	Fields FieldsConfig `json:"fields"`
+	// ErrorPolicy decides what happens to malformed rows: "fail-fast" (default), "skip" or "quarantine"
+	ErrorPolicy string `json:"errorPolicy"`
+	// MaxRejects aborts the run once more rows are rejected, zero means no limit
+	MaxRejects int `json:"maxRejects"`
+	// DeadLetterPath is the CSV file quarantined rows are written to.
+	// When empty they are saved to the rejected_rows ClickHouse table.
+	DeadLetterPath string `json:"deadLetterPath"`
+}
+
+// FieldsConfig maps transaction fields onto JSON paths of the form <column>.<key>[.<key>...].
+// Empty paths fall back to the layout of the marketplace exports.
+type FieldsConfig struct {
+	Symbol string `json:"symbol"`
+	Amount string `json:"amount"`
+	// Chain and Contract locate the optional network and token contract address of the currency
+	Chain    string            `json:"chain"`
+	Contract string            `json:"contract"`
+	Extra    map[string]string `json:"extra"`
+}
+
+// CoinIDsConfig maps currency symbols onto CoinGecko coin IDs, e.g. "usdc" -> "usd-coin".
+// Project overrides take precedence over the ones for every project.
+type CoinIDsConfig struct {
+	Symbols  map[string]string            `json:"symbols"`
+	Projects map[string]map[string]string `json:"projects"`
+}
+
+// PegConfig pegs a currency either to a fixed USD price, e.g. 1 for USDC, or to the coin it wraps, e.g. "ethereum" for WETH
+type PegConfig struct {
+	Price  float64 `json:"price"`
+	CoinID string  `json:"coinId"`
+}
+
+// PriceSanityConfig bounds the prices of a run, no price is checked when both bounds are zero
+type PriceSanityConfig struct {
+	// MaxDailyMove is the largest factor a price may move by from the previous day, e.g. 3
+	MaxDailyMove float64 `json:"maxDailyMove"`
+	// MaxDeviation is the largest relative deviation from the price of ReferenceProvider, e.g. 0.1
+	MaxDeviation float64 `json:"maxDeviation"`
+	// ReferenceProvider is the price provider prices are compared with: "coingecko", "file" or "binance",
+	// it must not be one of PriceProviders
+	ReferenceProvider string `json:"referenceProvider"`
+	// Policy decides what happens to suspicious prices: "reject" (default) fails the run, "quarantine" leaves
+	// their transactions out of the aggregates and saves them to the quarantined_prices ClickHouse table
+	Policy string `json:"policy"`
 }
 
 // LoadConfig reads the config.json file and unmarshals it into a Config struct
@@ -35,5 +147,10 @@ func LoadConfig(filename string) (*Config, error) {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	// GCS was the only source before sources became configurable
+	if config.Source == "" {
+		config.Source = "gcs"
+	}
+
 	return &config, nil
 }
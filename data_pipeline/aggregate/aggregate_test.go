@@ -1,10 +1,12 @@
 package aggregate
 
 import (
+	"math"
 	"testing"
 	"time"
 
 	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,37 +16,55 @@ const (
 	ETHPrice = 1500.0
 )
 
+// normalized rebuilds the volumes of the data from their strings, so equal volumes compare equal
+// whatever their exponent
+func normalized(data []models.MarketplaceData) []models.MarketplaceData {
+	result := make([]models.MarketplaceData, len(data))
+	for i, d := range data {
+		d.TotalVolumeUSD = decimal.RequireFromString(d.TotalVolumeUSD.String())
+		if d.Volumes != nil {
+			volumes := make(map[string]decimal.Decimal, len(d.Volumes))
+			for fiat, volume := range d.Volumes {
+				volumes[fiat] = decimal.RequireFromString(volume.String())
+			}
+			d.Volumes = volumes
+		}
+		result[i] = d
+	}
+	return result
+}
+
 func TestAggregateTransactions_Basic(t *testing.T) {
 	transactions := []models.Transaction{
 		{
 			Date:                 time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
 			ProjectID:            "project_1",
 			CurrencySymbol:       "ETH",
-			CurrencyValueDecimal: 2.0,
+			CurrencyValueDecimal: decimal.NewFromFloat(2.0),
 		},
 		{
 			Date:                 time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
 			ProjectID:            "project_1",
 			CurrencySymbol:       "ETH",
-			CurrencyValueDecimal: 3.0,
+			CurrencyValueDecimal: decimal.NewFromFloat(3.0),
 		},
 		{
 			Date:                 time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC),
 			ProjectID:            "project_1",
 			CurrencySymbol:       "BTC",
-			CurrencyValueDecimal: 1.0,
+			CurrencyValueDecimal: decimal.NewFromFloat(1.0),
 		},
 		{
 			Date:                 time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC),
 			ProjectID:            "project_2",
 			CurrencySymbol:       "BTC",
-			CurrencyValueDecimal: 0.5,
+			CurrencyValueDecimal: decimal.NewFromFloat(0.5),
 		},
 	}
 
-	priceMap := map[string]float64{
-		"ETH": ETHPrice,
-		"BTC": BTCPrice,
+	priceMap := models.PriceMap{
+		{Symbol: "ETH", Date: "2024-04-01"}: ETHPrice,
+		{Symbol: "BTC", Date: "2024-04-02"}: BTCPrice,
 	}
 
 	expected := []models.MarketplaceData{
@@ -52,30 +72,30 @@ func TestAggregateTransactions_Basic(t *testing.T) {
 			Date:            "2024-04-01",
 			ProjectID:       "project_1",
 			NumTransactions: 2,
-			TotalVolumeUSD:  5 * ETHPrice, // 2 ETH + 3 ETH = 5 ETH * 1500 = 7500
+			TotalVolumeUSD:  decimal.NewFromFloat(5 * ETHPrice), // 2 ETH + 3 ETH = 5 ETH * 1500 = 7500
 		},
 		{
 			Date:            "2024-04-02",
 			ProjectID:       "project_1",
 			NumTransactions: 1,
-			TotalVolumeUSD:  BTCPrice, // 1 BTC * 30000 = 30000
+			TotalVolumeUSD:  decimal.NewFromFloat(BTCPrice), // 1 BTC * 30000 = 30000
 		},
 		{
 			Date:            "2024-04-02",
 			ProjectID:       "project_2",
 			NumTransactions: 1,
-			TotalVolumeUSD:  0.5 * BTCPrice, // 0.5 BTC * 30000 = 15000
+			TotalVolumeUSD:  decimal.NewFromFloat(0.5 * BTCPrice), // 0.5 BTC * 30000 = 15000
 		},
 	}
 
 	result, err := AggregateTransactions(transactions, priceMap)
 	assert.NoError(t, err)
-	assert.ElementsMatch(t, expected, result)
+	assert.ElementsMatch(t, normalized(expected), normalized(result))
 }
 
 func TestAggregateTransactions_Empty(t *testing.T) {
 	transactions := []models.Transaction{}
-	priceMap := map[string]float64{}
+	priceMap := models.PriceMap{}
 
 	_, err := AggregateTransactions(transactions, priceMap)
 	assert.ErrorContains(t, err, "no transactions to aggregate")
@@ -87,16 +107,23 @@ func TestAggregateTransactions_NoPriceInMap(t *testing.T) {
 			Date:                 time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
 			ProjectID:            "project_1",
 			CurrencySymbol:       "ETH",
-			CurrencyValueDecimal: 2.0,
+			CurrencyValueDecimal: decimal.NewFromFloat(2.0),
 		},
 	}
 
-	priceMap := map[string]float64{
-		// Empty price map, no price for "ETH"
+	priceMap := models.PriceMap{
+		// No price for "ETH" on the day of the transaction
+		{Symbol: "ETH", Date: "2024-04-02"}: ETHPrice,
 	}
 
 	_, err := AggregateTransactions(transactions, priceMap)
-	assert.ErrorContains(t, err, "no price found for")
+	assert.ErrorContains(t, err, "no price found for ETH on 2024-04-01")
+
+	// prices which are not finite are an error, not a panic
+	for _, price := range []float64{math.NaN(), math.Inf(1)} {
+		_, err = AggregateTransactions(transactions, models.PriceMap{{Symbol: "ETH", Date: "2024-04-01"}: price})
+		assert.ErrorContains(t, err, "invalid price")
+	}
 }
 
 func TestAggregateTransactions_MultipleProjects(t *testing.T) {
@@ -105,19 +132,19 @@ func TestAggregateTransactions_MultipleProjects(t *testing.T) {
 			Date:                 time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
 			ProjectID:            "project_1",
 			CurrencySymbol:       "ETH",
-			CurrencyValueDecimal: 2.0,
+			CurrencyValueDecimal: decimal.NewFromFloat(2.0),
 		},
 		{
 			Date:                 time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
 			ProjectID:            "project_2",
 			CurrencySymbol:       "BTC",
-			CurrencyValueDecimal: 1.0,
+			CurrencyValueDecimal: decimal.NewFromFloat(1.0),
 		},
 	}
 
-	priceMap := map[string]float64{
-		"ETH": ETHPrice,
-		"BTC": BTCPrice,
+	priceMap := models.PriceMap{
+		{Symbol: "ETH", Date: "2024-04-01"}: ETHPrice,
+		{Symbol: "BTC", Date: "2024-04-01"}: BTCPrice,
 	}
 
 	expected := []models.MarketplaceData{
@@ -125,17 +152,329 @@ func TestAggregateTransactions_MultipleProjects(t *testing.T) {
 			Date:            "2024-04-01",
 			ProjectID:       "project_1",
 			NumTransactions: 1,
-			TotalVolumeUSD:  2 * ETHPrice, // 2 ETH * 1500 = 3000
+			TotalVolumeUSD:  decimal.NewFromFloat(2 * ETHPrice), // 2 ETH * 1500 = 3000
 		},
 		{
 			Date:            "2024-04-01",
 			ProjectID:       "project_2",
 			NumTransactions: 1,
-			TotalVolumeUSD:  1 * BTCPrice, // 1 BTC * 30000 = 30000
+			TotalVolumeUSD:  decimal.NewFromFloat(1 * BTCPrice), // 1 BTC * 30000 = 30000
 		},
 	}
 
 	result, err := AggregateTransactions(transactions, priceMap)
 	assert.NoError(t, err)
-	assert.ElementsMatch(t, expected, result)
+	assert.ElementsMatch(t, normalized(expected), normalized(result))
+}
+
+func TestAggregator_ExactVolumes(t *testing.T) {
+	aggregator := NewAggregator(nil)
+	date := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	// 0.1 + 0.2 is not 0.3 in floating point, and a float64 keeps about 16 significant digits
+	for _, amount := range []string{"0.1", "0.2", "12345678901234567.123456789012345678"} {
+		aggregator.Add(models.Transaction{Date: date, ProjectID: "project_1", CurrencySymbol: "SFL", CurrencyValueDecimal: decimal.RequireFromString(amount)})
+	}
+
+	result, err := aggregator.Result(models.PriceMap{{Symbol: "SFL", Date: "2024-04-01"}: 0.07})
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+	// the price is taken at its shortest representation 0.07
+	assert.Equal(t, "864197523086419.71964197523086419746", result[0].TotalVolumeUSD.String())
+}
+
+func TestAggregator_Incremental(t *testing.T) {
+	aggregator := NewAggregator(nil)
+	for i := 0; i < 1000; i++ {
+		aggregator.Add(models.Transaction{
+			Date:                 time.Date(2024, 4, 1, i%24, 0, 0, 0, time.UTC),
+			ProjectID:            "project_1",
+			CurrencySymbol:       "ETH",
+			CurrencyValueDecimal: decimal.NewFromFloat(0.5),
+		})
+	}
+	aggregator.Add(models.Transaction{
+		Date:                 time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC),
+		ProjectID:            "project_1",
+		CurrencySymbol:       "BTC",
+		CurrencyValueDecimal: decimal.NewFromFloat(1.0),
+	})
+
+	// one group per (day, project, currency) no matter how many transactions were added
+	assert.Len(t, aggregator.groups, 2)
+
+	result, err := aggregator.Result(models.PriceMap{
+		{Symbol: "ETH", Date: "2024-04-01"}: ETHPrice,
+		{Symbol: "BTC", Date: "2024-04-01"}: BTCPrice,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, normalized([]models.MarketplaceData{
+		{
+			Date:            "2024-04-01",
+			ProjectID:       "project_1",
+			NumTransactions: 1001,
+			TotalVolumeUSD:  decimal.NewFromFloat(500*ETHPrice + BTCPrice),
+		},
+	}), normalized(result))
+}
+
+func TestAggregator_ReportingLocation(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+
+	// 02:00 UTC on April 2nd is still April 1st in New York
+	transactions := []models.Transaction{
+		{
+			Date:                 time.Date(2024, 4, 1, 15, 0, 0, 0, time.UTC),
+			ProjectID:            "project_1",
+			CurrencySymbol:       "ETH",
+			CurrencyValueDecimal: decimal.NewFromFloat(1.0),
+		},
+		{
+			Date:                 time.Date(2024, 4, 2, 2, 0, 0, 0, time.UTC),
+			ProjectID:            "project_1",
+			CurrencySymbol:       "ETH",
+			CurrencyValueDecimal: decimal.NewFromFloat(1.0),
+		},
+	}
+	priceMap := models.PriceMap{
+		{Symbol: "ETH", Date: "2024-04-01"}: ETHPrice,
+		{Symbol: "ETH", Date: "2024-04-02"}: ETHPrice,
+	}
+
+	aggregator := NewAggregator(newYork)
+	for _, txn := range transactions {
+		aggregator.Add(txn)
+	}
+	result, err := aggregator.Result(priceMap)
+	assert.NoError(t, err)
+	assert.Equal(t, normalized([]models.MarketplaceData{
+		{Date: "2024-04-01", ProjectID: "project_1", NumTransactions: 2, TotalVolumeUSD: decimal.NewFromFloat(2 * ETHPrice)},
+	}), normalized(result))
+
+	// bucketed by UTC days the transactions fall on different days
+	result, err = AggregateTransactions(transactions, priceMap)
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+}
+
+func TestAggregator_PricesPerDay(t *testing.T) {
+	aggregator := NewAggregator(nil)
+	for day := 1; day <= 2; day++ {
+		for _, projectID := range []string{"project_1", "project_2"} {
+			aggregator.Add(models.Transaction{
+				Date:                 time.Date(2024, 4, day, 0, 0, 0, 0, time.UTC),
+				ProjectID:            projectID,
+				CurrencySymbol:       "ETH",
+				CurrencyValueDecimal: decimal.NewFromFloat(1.0),
+			})
+		}
+	}
+
+	// one price per currency and day, no matter how many projects used it
+	assert.Equal(t, []models.PriceKey{
+		{Symbol: "ETH", Date: "2024-04-01"},
+		{Symbol: "ETH", Date: "2024-04-02"},
+	}, aggregator.PriceKeys())
+
+	// every day is converted with its own price
+	result, err := aggregator.Result(models.PriceMap{
+		{Symbol: "ETH", Date: "2024-04-01"}: 1000,
+		{Symbol: "ETH", Date: "2024-04-02"}: 2000,
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, normalized([]models.MarketplaceData{
+		{Date: "2024-04-01", ProjectID: "project_1", NumTransactions: 1, TotalVolumeUSD: decimal.NewFromInt(1000)},
+		{Date: "2024-04-01", ProjectID: "project_2", NumTransactions: 1, TotalVolumeUSD: decimal.NewFromInt(1000)},
+		{Date: "2024-04-02", ProjectID: "project_1", NumTransactions: 1, TotalVolumeUSD: decimal.NewFromInt(2000)},
+		{Date: "2024-04-02", ProjectID: "project_2", NumTransactions: 1, TotalVolumeUSD: decimal.NewFromInt(2000)},
+	}), normalized(result))
+}
+
+func TestAggregator_IntradayPrices(t *testing.T) {
+	aggregator := NewAggregator(nil)
+	aggregator.SetResolution(time.Hour)
+	for _, minute := range []int{5, 25, 40} {
+		aggregator.Add(models.Transaction{
+			Date:                 time.Date(2024, 4, 1, 10, minute, 0, 0, time.UTC),
+			ProjectID:            "project_1",
+			CurrencySymbol:       "ETH",
+			CurrencyValueDecimal: decimal.NewFromFloat(1.0),
+		})
+	}
+
+	// the transactions are priced at their nearest hour, 10:40 at 11:00
+	ten := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC).Unix()
+	eleven := time.Date(2024, 4, 1, 11, 0, 0, 0, time.UTC).Unix()
+	assert.Equal(t, []models.PriceKey{
+		{Symbol: "ETH", Date: "2024-04-01", Time: ten},
+		{Symbol: "ETH", Date: "2024-04-01", Time: eleven},
+	}, aggregator.PriceKeys())
+
+	result, err := aggregator.Result(models.PriceMap{
+		{Symbol: "ETH", Date: "2024-04-01", Time: ten}:    1000,
+		{Symbol: "ETH", Date: "2024-04-01", Time: eleven}: 1100,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, normalized([]models.MarketplaceData{
+		{Date: "2024-04-01", ProjectID: "project_1", NumTransactions: 3, TotalVolumeUSD: decimal.NewFromInt(3100)},
+	}), normalized(result))
+
+	_, err = aggregator.Result(models.PriceMap{{Symbol: "ETH", Date: "2024-04-01", Time: ten}: 1000})
+	assert.ErrorContains(t, err, "no price found for ETH on 2024-04-01T11:00:00Z")
+
+	_, err = Granularity("weekly").Resolution()
+	assert.ErrorContains(t, err, "unknown price granularity")
+}
+
+func TestAggregator_FiatCurrencies(t *testing.T) {
+	aggregator := NewAggregator(nil)
+	aggregator.SetFiatCurrencies("EUR", "usd", "eur", "gbp")
+	date := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	aggregator.Add(models.Transaction{Date: date, ProjectID: "project_1", CurrencySymbol: "ETH", CurrencyValueDecimal: decimal.NewFromInt(2)})
+
+	// USD is always reported, every other currency once
+	assert.Equal(t, []models.PriceKey{
+		{Symbol: "ETH", Date: "2024-04-01"},
+		{Symbol: "ETH", Date: "2024-04-01", Fiat: "eur"},
+		{Symbol: "ETH", Date: "2024-04-01", Fiat: "gbp"},
+	}, aggregator.PriceKeys())
+
+	result, err := aggregator.Result(models.PriceMap{
+		{Symbol: "ETH", Date: "2024-04-01"}:              3000,
+		{Symbol: "ETH", Date: "2024-04-01", Fiat: "eur"}: 2800,
+		{Symbol: "ETH", Date: "2024-04-01", Fiat: "gbp"}: 2400,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, normalized([]models.MarketplaceData{{
+		Date:            "2024-04-01",
+		ProjectID:       "project_1",
+		NumTransactions: 1,
+		TotalVolumeUSD:  decimal.NewFromInt(6000),
+		Volumes:         map[string]decimal.Decimal{"eur": decimal.NewFromInt(5600), "gbp": decimal.NewFromInt(4800)},
+	}}), normalized(result))
+
+	_, err = aggregator.Result(models.PriceMap{
+		{Symbol: "ETH", Date: "2024-04-01"}:              3000,
+		{Symbol: "ETH", Date: "2024-04-01", Fiat: "eur"}: 2800,
+	})
+	assert.ErrorContains(t, err, "no GBP price found for ETH on 2024-04-01")
+}
+
+func TestAggregator_MarkOverridden(t *testing.T) {
+	aggregator := NewAggregator(nil)
+	for day := 1; day <= 2; day++ {
+		aggregator.Add(models.Transaction{
+			Date:                 time.Date(2024, 4, day, 0, 0, 0, 0, time.UTC),
+			ProjectID:            "project_1",
+			CurrencySymbol:       "GALA",
+			CurrencyValueDecimal: decimal.NewFromInt(100),
+		})
+	}
+	aggregator.MarkOverridden(models.PriceKey{Symbol: "GALA", Date: "2024-04-02"})
+
+	result, err := aggregator.Result(models.PriceMap{
+		{Symbol: "GALA", Date: "2024-04-01"}: 0.04,
+		{Symbol: "GALA", Date: "2024-04-02"}: 0.05,
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, normalized([]models.MarketplaceData{
+		{Date: "2024-04-01", ProjectID: "project_1", NumTransactions: 1, TotalVolumeUSD: decimal.NewFromInt(4)},
+		{Date: "2024-04-02", ProjectID: "project_1", NumTransactions: 1, TotalVolumeUSD: decimal.NewFromInt(5), PriceOverridden: true},
+	}), normalized(result))
+}
+
+func TestAggregator_ExcludePrices(t *testing.T) {
+	aggregator := NewAggregator(nil)
+	aggregator.SetFiatCurrencies("eur")
+	date := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	aggregator.Add(models.Transaction{Date: date, ProjectID: "project_1", CurrencySymbol: "ETH", CurrencyValueDecimal: decimal.NewFromInt(1)})
+	aggregator.Add(models.Transaction{Date: date, ProjectID: "project_1", CurrencySymbol: "GALA", CurrencyValueDecimal: decimal.NewFromInt(100)})
+	aggregator.Add(models.Transaction{Date: date, ProjectID: "project_2", CurrencySymbol: "GALA", CurrencyValueDecimal: decimal.NewFromInt(100)})
+
+	// a suspicious price in any fiat currency drops the transactions it converts
+	aggregator.ExcludePrices(models.PriceKey{Symbol: "GALA", Date: "2024-04-01", Fiat: "eur"})
+
+	result, err := aggregator.Result(models.PriceMap{
+		{Symbol: "ETH", Date: "2024-04-01"}:              3000,
+		{Symbol: "ETH", Date: "2024-04-01", Fiat: "eur"}: 2800,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, normalized([]models.MarketplaceData{
+		{Date: "2024-04-01", ProjectID: "project_1", NumTransactions: 1, TotalVolumeUSD: decimal.NewFromInt(3000), Volumes: map[string]decimal.Decimal{"eur": decimal.NewFromInt(2800)}},
+	}), normalized(result))
+}
+
+func TestAggregator_PinnedCoins(t *testing.T) {
+	aggregator := NewAggregator(nil)
+	date := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	aggregator.Add(models.Transaction{Date: date, ProjectID: "project_1", CurrencySymbol: "USDC", CurrencyValueDecimal: decimal.NewFromInt(10)})
+	aggregator.Add(models.Transaction{Date: date, ProjectID: "project_2", CurrencySymbol: "USDC", CoinID: "bridged-usdc", CurrencyValueDecimal: decimal.NewFromInt(10)})
+
+	// the same symbol pinned to another coin needs its own price
+	assert.Equal(t, []models.PriceKey{
+		{Symbol: "USDC", Date: "2024-04-01"},
+		{Symbol: "USDC", Date: "2024-04-01", CoinID: "bridged-usdc"},
+	}, aggregator.PriceKeys())
+
+	result, err := aggregator.Result(models.PriceMap{
+		{Symbol: "USDC", Date: "2024-04-01"}:                         1,
+		{Symbol: "USDC", Date: "2024-04-01", CoinID: "bridged-usdc"}: 0.5,
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, normalized([]models.MarketplaceData{
+		{Date: "2024-04-01", ProjectID: "project_1", NumTransactions: 1, TotalVolumeUSD: decimal.NewFromInt(10)},
+		{Date: "2024-04-01", ProjectID: "project_2", NumTransactions: 1, TotalVolumeUSD: decimal.NewFromInt(5)},
+	}), normalized(result))
+
+	_, err = aggregator.Result(models.PriceMap{{Symbol: "USDC", Date: "2024-04-01"}: 1})
+	assert.ErrorContains(t, err, "no price found for USDC (bridged-usdc) on 2024-04-01")
+}
+
+func TestAggregator_CurrencyTotalsAndExclude(t *testing.T) {
+	aggregator := NewAggregator(nil)
+	date := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	aggregator.Add(models.Transaction{Date: date, ProjectID: "project_1", CurrencySymbol: "FOO", CurrencyValueDecimal: decimal.NewFromInt(2)})
+	aggregator.Add(models.Transaction{Date: date.AddDate(0, 0, 1), ProjectID: "project_1", CurrencySymbol: "FOO", CurrencyValueDecimal: decimal.NewFromInt(3)})
+	aggregator.Add(models.Transaction{Date: date, ProjectID: "project_2", CurrencySymbol: "FOO", CurrencyValueDecimal: decimal.NewFromInt(5)})
+	aggregator.Add(models.Transaction{Date: date, ProjectID: "project_2", CurrencySymbol: "FOO", CoinID: "foo-coin", CurrencyValueDecimal: decimal.NewFromInt(1)})
+	aggregator.Add(models.Transaction{Date: date, ProjectID: "project_2", CurrencySymbol: "FOO", Chain: "base", ContractAddress: "0xf00", CurrencyValueDecimal: decimal.NewFromInt(7)})
+	aggregator.Add(models.Transaction{Date: date, ProjectID: "project_1", CurrencySymbol: "ETH", CurrencyValueDecimal: decimal.NewFromInt(1)})
+
+	// pinned transactions are not priced by their symbol
+	totals := aggregator.CurrencyTotals()
+	assert.Len(t, totals, 2)
+	assert.Equal(t, uint64(3), totals["FOO"].NumTransactions)
+	assert.Equal(t, "10", totals["FOO"].TotalValue.String())
+	assert.Equal(t, 2, totals["FOO"].NumProjects)
+	assert.Equal(t, uint64(1), totals["ETH"].NumTransactions)
+	assert.Equal(t, "1", totals["ETH"].TotalValue.String())
+	assert.Equal(t, 1, totals["ETH"].NumProjects)
+
+	// pinned transactions are kept
+	aggregator.Exclude("FOO")
+	assert.Equal(t, []models.PriceKey{
+		{Symbol: "ETH", Date: "2024-04-01"},
+		{Symbol: "FOO", Date: "2024-04-01", Chain: "base", Contract: "0xf00"},
+		{Symbol: "FOO", Date: "2024-04-01", CoinID: "foo-coin"},
+	}, aggregator.PriceKeys())
+}
+
+func TestAggregator_Unpin(t *testing.T) {
+	aggregator := NewAggregator(nil)
+	date := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	aggregator.Add(models.Transaction{Date: date, ProjectID: "project_1", CurrencySymbol: "FOO", CurrencyValueDecimal: decimal.NewFromInt(2)})
+	aggregator.Add(models.Transaction{Date: date, ProjectID: "project_1", CurrencySymbol: "FOO", Chain: "base", ContractAddress: "0xf00", CurrencyValueDecimal: decimal.NewFromInt(3)})
+	aggregator.Add(models.Transaction{Date: date, ProjectID: "project_2", CurrencySymbol: "FOO", Chain: "base", ContractAddress: "0xf00", CurrencyValueDecimal: decimal.NewFromInt(5)})
+	aggregator.Add(models.Transaction{Date: date, ProjectID: "project_2", CurrencySymbol: "FOO", Chain: "ethereum", ContractAddress: "0xf01", CurrencyValueDecimal: decimal.NewFromInt(7)})
+
+	// the unknown contract is priced by its symbol, merged with the transactions in the symbol
+	aggregator.Unpin(models.PriceKey{Symbol: "FOO", Chain: "base", Contract: "0xf00"})
+	assert.Equal(t, []models.PriceKey{
+		{Symbol: "FOO", Date: "2024-04-01"},
+		{Symbol: "FOO", Date: "2024-04-01", Chain: "ethereum", Contract: "0xf01"},
+	}, aggregator.PriceKeys())
+	totals := aggregator.CurrencyTotals()
+	assert.Equal(t, uint64(3), totals["FOO"].NumTransactions)
+	assert.Equal(t, "10", totals["FOO"].TotalValue.String())
+	assert.Equal(t, 2, totals["FOO"].NumProjects)
 }
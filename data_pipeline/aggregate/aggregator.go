@@ -2,36 +2,348 @@ package aggregate
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/shopspring/decimal"
 )
 
-// AggregateTransactions aggregates the given transactions by day and project ID
-func AggregateTransactions(transactions []models.Transaction, priceMap map[string]float64) ([]models.MarketplaceData, error) {
-	if len(transactions) == 0 {
+// Granularity is the time resolution transactions are priced at
+type Granularity string
+
+const (
+	// GranularityDaily prices the transactions of a day at the price of the day
+	GranularityDaily  Granularity = "daily"
+	GranularityHourly Granularity = "hourly"
+	GranularityMinute Granularity = "minute"
+)
+
+// Resolution returns the interval transaction times are rounded to for pricing, zero for the price of the day
+func (granularity Granularity) Resolution() (time.Duration, error) {
+	switch granularity {
+	case "", GranularityDaily:
+		return 0, nil
+	case GranularityHourly:
+		return time.Hour, nil
+	case GranularityMinute:
+		return time.Minute, nil
+	}
+	return 0, fmt.Errorf("unknown price granularity %q", granularity)
+}
+
+// groupKey identifies the transactions of a single day, project and currency
+type groupKey struct {
+	day string
+	// instant is the Unix time the transactions are priced at, zero for the price of the day
+	instant        int64
+	projectID      string
+	currencySymbol string
+	// coinID pins the currency to a CoinGecko coin, empty to resolve the symbol
+	coinID string
+	// chain and contract identify the currency by its token contract, empty to resolve the symbol
+	chain    string
+	contract string
+}
+
+// priceKey returns the key of the price the group is converted to the fiat currency with, USD when empty
+func (key groupKey) priceKey(fiat string) models.PriceKey {
+	return models.PriceKey{Symbol: key.currencySymbol, Date: key.day, Time: key.instant, CoinID: key.coinID, Chain: key.chain, Contract: key.contract, Fiat: fiat}
+}
+
+// pinned reports whether the group is priced by a coin ID or a token contract rather than by its symbol
+func (key groupKey) pinned() bool {
+	return key.coinID != "" || key.priceKey("").HasContract()
+}
+
+// price returns the price of the group in the fiat currency, USD when empty, failing when it is missing or not finite
+func (key groupKey) price(priceMap models.PriceMap, fiat string) (decimal.Decimal, error) {
+	price := priceMap[key.priceKey(fiat)]
+	if price == 0 {
+		return decimal.Decimal{}, key.missingPrice(fiat)
+	}
+	// NewFromFloat panics on NaN and Inf
+	if math.IsNaN(price) || math.IsInf(price, 0) {
+		return decimal.Decimal{}, fmt.Errorf("invalid price %g for %s on %s", price, key.currencySymbol, key.when())
+	}
+	return decimal.NewFromFloat(price), nil
+}
+
+// missingPrice returns the error for a group without a price in the fiat currency, USD when empty
+func (key groupKey) missingPrice(fiat string) error {
+	price := "price"
+	if fiat != "" {
+		price = strings.ToUpper(fiat) + " price"
+	}
+	if key.coinID != "" {
+		return fmt.Errorf("no %s found for %s (%s) on %s", price, key.currencySymbol, key.coinID, key.when())
+	}
+	if key.contract != "" {
+		return fmt.Errorf("no %s found for %s (%s on %s) on %s", price, key.currencySymbol, key.contract, key.chain, key.when())
+	}
+	return fmt.Errorf("no %s found for %s on %s", price, key.currencySymbol, key.when())
+}
+
+// when describes the day or instant the group is priced at
+func (key groupKey) when() string {
+	if key.instant != 0 {
+		return time.Unix(key.instant, 0).UTC().Format(time.RFC3339)
+	}
+	return key.day
+}
+
+// group holds the running totals of a group, before the currency is converted to USD
+type group struct {
+	numTransactions uint64
+	totalValue      decimal.Decimal
+}
+
+// Aggregator aggregates transactions incrementally as they are streamed.
+// Its memory is bounded by the number of (day, project, currency) groups rather than the number of transactions.
+type Aggregator struct {
+	groups map[groupKey]*group
+	// location whose calendar days the transactions are bucketed by
+	location *time.Location
+	// resolution transactions are priced at within the day, zero for the price of the day
+	resolution time.Duration
+	// lowercase codes of the fiat currencies the volume is reported in besides USD
+	fiats []string
+	// prices overridden by hand, the aggregates computed with them are flagged
+	overridden map[models.PriceKey]bool
+}
+
+// NewAggregator creates a new, empty Aggregator reporting days in the given location, UTC when nil.
+func NewAggregator(location *time.Location) *Aggregator {
+	if location == nil {
+		location = time.UTC
+	}
+	return &Aggregator{
+		groups:   make(map[groupKey]*group),
+		location: location,
+	}
+}
+
+// SetResolution prices the transactions added from now on at their time rounded to the resolution,
+// instead of at the price of their day. Every instant is its own group, so finer resolutions cost more memory.
+func (aggregator *Aggregator) SetResolution(resolution time.Duration) {
+	aggregator.resolution = resolution
+}
+
+// SetFiatCurrencies reports the volume in the given fiat currencies besides USD, e.g. "eur".
+// Every currency needs its own prices.
+func (aggregator *Aggregator) SetFiatCurrencies(fiats ...string) {
+	aggregator.fiats = nil
+	seen := map[string]bool{"usd": true}
+	for _, fiat := range fiats {
+		fiat = strings.ToLower(strings.TrimSpace(fiat))
+		if fiat != "" && !seen[fiat] {
+			seen[fiat] = true
+			aggregator.fiats = append(aggregator.fiats, fiat)
+		}
+	}
+}
+
+// MarkOverridden flags the aggregates computed with any of the given prices as using overridden prices
+func (aggregator *Aggregator) MarkOverridden(keys ...models.PriceKey) {
+	if aggregator.overridden == nil {
+		aggregator.overridden = make(map[models.PriceKey]bool, len(keys))
+	}
+	for _, key := range keys {
+		aggregator.overridden[key] = true
+	}
+}
+
+// Add adds a single transaction to the running totals
+func (aggregator *Aggregator) Add(txn models.Transaction) {
+	var instant int64
+	if aggregator.resolution > 0 {
+		instant = txn.Date.Round(aggregator.resolution).Unix()
+	}
+	key := groupKey{
+		day:            txn.Date.In(aggregator.location).Format("2006-01-02"),
+		instant:        instant,
+		projectID:      txn.ProjectID,
+		currencySymbol: txn.CurrencySymbol,
+		coinID:         txn.CoinID,
+		chain:          txn.Chain,
+		contract:       txn.ContractAddress,
+	}
+
+	g, ok := aggregator.groups[key]
+	if !ok {
+		g = &group{}
+		aggregator.groups[key] = g
+	}
+	g.numTransactions++
+	g.totalValue = g.totalValue.Add(txn.CurrencyValueDecimal)
+}
+
+// PriceKeys returns the distinct (currency, day, fiat currency) keys which need a price to compute the result
+func (aggregator *Aggregator) PriceKeys() []models.PriceKey {
+	seen := make(map[models.PriceKey]bool)
+	var keys []models.PriceKey
+	for key := range aggregator.groups {
+		for _, fiat := range append([]string{""}, aggregator.fiats...) {
+			priceKey := key.priceKey(fiat)
+			if !seen[priceKey] {
+				seen[priceKey] = true
+				keys = append(keys, priceKey)
+			}
+		}
+	}
+
+	// sorted, so prices are fetched in a predictable order
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Date != keys[j].Date {
+			return keys[i].Date < keys[j].Date
+		}
+		if keys[i].Time != keys[j].Time {
+			return keys[i].Time < keys[j].Time
+		}
+		if keys[i].Symbol != keys[j].Symbol {
+			return keys[i].Symbol < keys[j].Symbol
+		}
+		if keys[i].CoinID != keys[j].CoinID {
+			return keys[i].CoinID < keys[j].CoinID
+		}
+		if keys[i].Chain != keys[j].Chain {
+			return keys[i].Chain < keys[j].Chain
+		}
+		if keys[i].Contract != keys[j].Contract {
+			return keys[i].Contract < keys[j].Contract
+		}
+		return keys[i].Fiat < keys[j].Fiat
+	})
+	return keys
+}
+
+// CurrencyTotals holds the transactions of a single currency symbol before they are converted to USD
+type CurrencyTotals struct {
+	NumTransactions uint64
+	// TotalValue is the volume in units of the currency
+	TotalValue decimal.Decimal
+	// NumProjects is the number of projects the currency was used in
+	NumProjects int
+}
+
+// CurrencyTotals returns the totals of every currency symbol, e.g. to report the impact of a missing price.
+// Transactions pinned to a coin or a token contract are not priced by their symbol and left out.
+func (aggregator *Aggregator) CurrencyTotals() map[string]CurrencyTotals {
+	totals := make(map[string]CurrencyTotals)
+	projects := make(map[string]map[string]bool)
+	for key, g := range aggregator.groups {
+		if key.pinned() {
+			continue
+		}
+		t := totals[key.currencySymbol]
+		t.NumTransactions += g.numTransactions
+		t.TotalValue = t.TotalValue.Add(g.totalValue)
+		if projects[key.currencySymbol] == nil {
+			projects[key.currencySymbol] = make(map[string]bool)
+		}
+		if !projects[key.currencySymbol][key.projectID] {
+			projects[key.currencySymbol][key.projectID] = true
+			t.NumProjects++
+		}
+		totals[key.currencySymbol] = t
+	}
+	return totals
+}
+
+// Exclude drops the transactions in the given currency symbols from the result, unless they are pinned to a coin
+// or a token contract
+func (aggregator *Aggregator) Exclude(symbols ...string) {
+	excluded := make(map[string]bool, len(symbols))
+	for _, symbol := range symbols {
+		excluded[symbol] = true
+	}
+	for key := range aggregator.groups {
+		if excluded[key.currencySymbol] && !key.pinned() {
+			delete(aggregator.groups, key)
+		}
+	}
+}
+
+// Unpin prices the transactions in the given token contracts by their symbol, e.g. when the contracts are unknown.
+// The contracts are matched by symbol, chain and address, transactions pinned to a coin are left as they are.
+func (aggregator *Aggregator) Unpin(contracts ...models.PriceKey) {
+	unpinned := make(map[models.PriceKey]bool, len(contracts))
+	for _, contract := range contracts {
+		unpinned[models.PriceKey{Symbol: contract.Symbol, Chain: contract.Chain, Contract: contract.Contract}] = true
+	}
+	for key, g := range aggregator.groups {
+		if key.coinID != "" || !unpinned[models.PriceKey{Symbol: key.currencySymbol, Chain: key.chain, Contract: key.contract}] {
+			continue
+		}
+		delete(aggregator.groups, key)
+		key.chain, key.contract = "", ""
+		// merged into the group of the symbol, if any
+		merged, ok := aggregator.groups[key]
+		if !ok {
+			merged = &group{}
+			aggregator.groups[key] = merged
+		}
+		merged.numTransactions += g.numTransactions
+		merged.totalValue = merged.totalValue.Add(g.totalValue)
+	}
+}
+
+// ExcludePrices drops the transactions converted with any of the given prices from the result, in every fiat currency
+func (aggregator *Aggregator) ExcludePrices(keys ...models.PriceKey) {
+	excluded := make(map[models.PriceKey]bool, len(keys))
+	for _, key := range keys {
+		excluded[key] = true
+	}
+	for key := range aggregator.groups {
+		for _, fiat := range append([]string{""}, aggregator.fiats...) {
+			if excluded[key.priceKey(fiat)] {
+				delete(aggregator.groups, key)
+				break
+			}
+		}
+	}
+}
+
+// Result converts the running totals to USD and the other fiat currencies and aggregates them by day and project ID.
+// The volumes are exact, every price is taken at the shortest decimal representation of its float.
+func (aggregator *Aggregator) Result(priceMap models.PriceMap) ([]models.MarketplaceData, error) {
+	if len(aggregator.groups) == 0 {
 		return nil, fmt.Errorf("no transactions to aggregate")
 	}
 	// hash map to group transactions by day and project ID
 	aggregated := make(map[string]models.MarketplaceData)
 
-	for _, txn := range transactions {
-		day := txn.Date.Format("2006-01-02")
-		key := day + "-" + txn.ProjectID
-
-		price := priceMap[txn.CurrencySymbol]
-		if price == 0 {
-			return nil, fmt.Errorf("no price found for %s", txn.CurrencySymbol)
+	for key, g := range aggregator.groups {
+		price, err := key.price(priceMap, "")
+		if err != nil {
+			return nil, err
 		}
 
-		transactionVolume := price * txn.CurrencyValueDecimal
-
-		agg := aggregated[key]
-		agg.Date = day
-		agg.ProjectID = txn.ProjectID
-		agg.NumTransactions++
-		agg.TotalVolumeUSD += transactionVolume
+		agg := aggregated[key.day+"-"+key.projectID]
+		agg.Date = key.day
+		agg.ProjectID = key.projectID
+		agg.NumTransactions += g.numTransactions
+		agg.TotalVolumeUSD = agg.TotalVolumeUSD.Add(price.Mul(g.totalValue))
+		if aggregator.overridden[key.priceKey("")] {
+			agg.PriceOverridden = true
+		}
+		for _, fiat := range aggregator.fiats {
+			fiatPrice, err := key.price(priceMap, fiat)
+			if err != nil {
+				return nil, err
+			}
+			if agg.Volumes == nil {
+				agg.Volumes = make(map[string]decimal.Decimal, len(aggregator.fiats))
+			}
+			agg.Volumes[fiat] = agg.Volumes[fiat].Add(fiatPrice.Mul(g.totalValue))
+			if aggregator.overridden[key.priceKey(fiat)] {
+				agg.PriceOverridden = true
+			}
+		}
 
-		aggregated[key] = agg
+		aggregated[key.day+"-"+key.projectID] = agg
 	}
 
 	// convert map to slice
@@ -42,3 +354,12 @@ func AggregateTransactions(transactions []models.Transaction, priceMap map[strin
 
 	return result, nil
 }
+
+// AggregateTransactions aggregates the given transactions by UTC day and project ID
+func AggregateTransactions(transactions []models.Transaction, priceMap models.PriceMap) ([]models.MarketplaceData, error) {
+	aggregator := NewAggregator(time.UTC)
+	for _, txn := range transactions {
+		aggregator.Add(txn)
+	}
+	return aggregator.Result(priceMap)
+}
@@ -0,0 +1,178 @@
+package coingecko
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// pricesBucket is the bbolt bucket holding the cached prices
+var pricesBucket = []byte("prices")
+
+// CacheKey identifies a cached historical price
+type CacheKey struct {
+	Provider string
+	CoinID   string
+	// Date in the format 2006-01-02
+	Date string
+	Fiat string
+}
+
+// bytes encodes the key as provider/coinID/date/fiat
+func (key CacheKey) bytes() []byte {
+	return []byte(strings.Join([]string{key.Provider, key.CoinID, key.Date, key.Fiat}, "/"))
+}
+
+// CacheStats counts the lookups served by a PriceCache
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// PriceCache is a durable store of historical prices shared across runs.
+// Historical prices never change, so cached entries never expire.
+type PriceCache struct {
+	db     *bolt.DB
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// OpenPriceCache opens the cache file at the given path, creating it if needed.
+func OpenPriceCache(path string) (*PriceCache, error) {
+	// fail instead of waiting forever when another run holds the file
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open price cache %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(pricesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize price cache %s: %v", path, err)
+	}
+	return &PriceCache{db: db}, nil
+}
+
+// CachedPrice is a cached price with the time it was fetched at
+type CachedPrice struct {
+	Price float64
+	// FetchedAt is zero for the entries cached before fetch times were recorded
+	FetchedAt time.Time
+}
+
+// Get returns the cached price for the key, counting the lookup as a hit or a miss
+func (cache *PriceCache) Get(key CacheKey) (float64, bool, error) {
+	cached, ok, err := cache.Lookup(key)
+	return cached.Price, ok, err
+}
+
+// Lookup returns the cached price for the key with its fetch time, counting the lookup as a hit or a miss
+func (cache *PriceCache) Lookup(key CacheKey) (CachedPrice, bool, error) {
+	cached, ok, err := cache.LookupAll([]CacheKey{key})
+	if !ok || err != nil {
+		return CachedPrice{}, ok, err
+	}
+	return cached[0], true, nil
+}
+
+// LookupAll returns the cached prices for keys which are cached together, e.g. the fiat currencies of a day.
+// ok is false unless every key is cached, the keys count as a single hit or miss.
+func (cache *PriceCache) LookupAll(keys []CacheKey) ([]CachedPrice, bool, error) {
+	values := make([][]byte, 0, len(keys))
+	err := cache.db.View(func(tx *bolt.Tx) error {
+		for _, key := range keys {
+			stored := tx.Bucket(pricesBucket).Get(key.bytes())
+			if stored == nil {
+				return nil
+			}
+			// the value is only valid inside the transaction
+			values = append(values, append([]byte(nil), stored...))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if len(values) < len(keys) {
+		cache.misses.Add(1)
+		return nil, false, nil
+	}
+
+	cached := make([]CachedPrice, len(keys))
+	for i, value := range values {
+		cached[i], err = decodeCachedPrice(string(value))
+		if err != nil {
+			return nil, false, fmt.Errorf("corrupt cache entry %s: %v", keys[i].bytes(), err)
+		}
+	}
+	cache.hits.Add(1)
+	return cached, true, nil
+}
+
+// Put stores the price for the key, fetched now
+func (cache *PriceCache) Put(key CacheKey, price float64) error {
+	value := strconv.FormatFloat(price, 'g', -1, 64) + "@" + strconv.FormatInt(time.Now().Unix(), 10)
+	return cache.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pricesBucket).Put(key.bytes(), []byte(value))
+	})
+}
+
+// decodeCachedPrice parses a price@unix entry, or a bare price cached before fetch times were recorded
+func decodeCachedPrice(value string) (CachedPrice, error) {
+	priceValue, fetchedValue, stamped := strings.Cut(value, "@")
+	price, err := strconv.ParseFloat(priceValue, 64)
+	if err != nil {
+		return CachedPrice{}, err
+	}
+	if !stamped {
+		return CachedPrice{Price: price}, nil
+	}
+	fetchedAt, err := strconv.ParseInt(fetchedValue, 10, 64)
+	if err != nil {
+		return CachedPrice{}, err
+	}
+	return CachedPrice{Price: price, FetchedAt: time.Unix(fetchedAt, 0).UTC()}, nil
+}
+
+// Len returns the number of cached prices
+func (cache *PriceCache) Len() (int, error) {
+	var n int
+	err := cache.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(pricesBucket).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// Purge removes every cached price and returns how many were removed
+func (cache *PriceCache) Purge() (int, error) {
+	var n int
+	err := cache.db.Update(func(tx *bolt.Tx) error {
+		n = tx.Bucket(pricesBucket).Stats().KeyN
+		if err := tx.DeleteBucket(pricesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(pricesBucket)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge price cache: %v", err)
+	}
+	return n, nil
+}
+
+// Stats returns the hits and misses of the lookups since the cache was opened
+func (cache *PriceCache) Stats() CacheStats {
+	return CacheStats{Hits: cache.hits.Load(), Misses: cache.misses.Load()}
+}
+
+// Close releases the cache file
+func (cache *PriceCache) Close() error {
+	return cache.db.Close()
+}
@@ -0,0 +1,152 @@
+package coingecko
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestPriceCache_GetPut(t *testing.T) {
+	cache, err := OpenPriceCache(filepath.Join(t.TempDir(), "prices.db"))
+	assert.NoError(t, err)
+	defer cache.Close()
+
+	key := CacheKey{Provider: "coingecko", CoinID: "ethereum", Date: "2024-04-01", Fiat: "usd"}
+	_, ok, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, cache.Put(key, 3500.25))
+	price, ok, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 3500.25, price)
+
+	// every part of the key matters
+	_, ok, err = cache.Get(CacheKey{Provider: "coingecko", CoinID: "ethereum", Date: "2024-04-01", Fiat: "eur"})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 2}, cache.Stats())
+}
+
+func TestPriceCache_Lookup(t *testing.T) {
+	cache, err := OpenPriceCache(filepath.Join(t.TempDir(), "prices.db"))
+	assert.NoError(t, err)
+	defer cache.Close()
+
+	key := CacheKey{Provider: "coingecko", CoinID: "ethereum", Date: "2024-04-01", Fiat: "usd"}
+	before := time.Now().Add(-time.Second)
+	assert.NoError(t, cache.Put(key, 3500.25))
+	cached, ok, err := cache.Lookup(key)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 3500.25, cached.Price)
+	assert.WithinRange(t, cached.FetchedAt, before, time.Now())
+
+	// entries cached before fetch times were recorded have none
+	err = cache.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pricesBucket).Put(key.bytes(), []byte("3400"))
+	})
+	assert.NoError(t, err)
+	cached, ok, err = cache.Lookup(key)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, CachedPrice{Price: 3400}, cached)
+}
+
+func TestPriceCache_Persistent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.db")
+	key := CacheKey{Provider: "coingecko", CoinID: "bitcoin", Date: "2024-04-01", Fiat: "usd"}
+
+	cache, err := OpenPriceCache(path)
+	assert.NoError(t, err)
+	assert.NoError(t, cache.Put(key, 70000))
+	assert.NoError(t, cache.Close())
+
+	cache, err = OpenPriceCache(path)
+	assert.NoError(t, err)
+	defer cache.Close()
+	price, ok, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 70000.0, price)
+}
+
+func TestPriceCache_Purge(t *testing.T) {
+	cache, err := OpenPriceCache(filepath.Join(t.TempDir(), "prices.db"))
+	assert.NoError(t, err)
+	defer cache.Close()
+
+	assert.NoError(t, cache.Put(CacheKey{Provider: "coingecko", CoinID: "bitcoin", Date: "2024-04-01", Fiat: "usd"}, 1))
+	assert.NoError(t, cache.Put(CacheKey{Provider: "coingecko", CoinID: "bitcoin", Date: "2024-04-02", Fiat: "usd"}, 2))
+
+	n, err := cache.Len()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = cache.Purge()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = cache.Len()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestCoinGeckoClient_GetPrices_Cached(t *testing.T) {
+	var requests int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`{"market_data": {"current_price": {"usd": 10}}}`))
+	}))
+	defer mockServer.Close()
+
+	cache, err := OpenPriceCache(filepath.Join(t.TempDir(), "prices.db"))
+	assert.NoError(t, err)
+	defer cache.Close()
+	assert.NoError(t, cache.Put(CacheKey{Provider: "coingecko", CoinID: "ethereum", Date: "2023-01-01", Fiat: "usd"}, 20))
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:          mockServer.URL,
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockGetCoinGeckoTokenIds,
+		cache:            cache,
+	}
+
+	keys := []models.PriceKey{
+		{Symbol: "ETH", Date: "2023-01-01"},
+		{Symbol: "BTC", Date: "2023-01-01"},
+	}
+	expected := models.PriceMap{
+		{Symbol: "ETH", Date: "2023-01-01"}: 20,
+		{Symbol: "BTC", Date: "2023-01-01"}: 10,
+	}
+
+	// only the missing price is fetched
+	priceMap, err := geckoClient.GetPrices(context.TODO(), keys)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, priceMap)
+	assert.Equal(t, int32(1), requests)
+	provenance := geckoClient.Provenance()
+	assert.True(t, provenance[keys[0]].CacheHit)
+	assert.Equal(t, "ethereum", provenance[keys[0]].CoinID)
+	assert.False(t, provenance[keys[1]].CacheHit)
+	assert.Equal(t, "bitcoin", provenance[keys[1]].CoinID)
+	assert.False(t, provenance[keys[1]].FetchedAt.IsZero())
+
+	// and served from the cache on the next run
+	priceMap, err = geckoClient.GetPrices(context.TODO(), keys)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, priceMap)
+	assert.Equal(t, int32(1), requests)
+	assert.Equal(t, CacheStats{Hits: 3, Misses: 1}, cache.Stats())
+}
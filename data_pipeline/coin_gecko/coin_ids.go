@@ -0,0 +1,204 @@
+package coingecko
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// knownCoinIDs holds the canonical coin of widely used symbols shared by bridged and copycat tokens
+var knownCoinIDs = map[string]string{
+	"btc":   "bitcoin",
+	"eth":   "ethereum",
+	"weth":  "weth",
+	"wbtc":  "wrapped-bitcoin",
+	"usdt":  "tether",
+	"usdc":  "usd-coin",
+	"dai":   "dai",
+	"bnb":   "binancecoin",
+	"sol":   "solana",
+	"matic": "matic-network",
+	"pol":   "polygon-ecosystem-token",
+	"avax":  "avalanche-2",
+	"arb":   "arbitrum",
+	"op":    "optimism",
+	"link":  "chainlink",
+	"uni":   "uniswap",
+	"imx":   "immutable-x",
+	"ron":   "ronin",
+	"ape":   "apecoin",
+}
+
+// derivativeMarkers flag bridged, wrapped and pegged copies of a coin in its ID or name
+var derivativeMarkers = []string{"bridged", "wrapped", "peg", "wormhole", "("}
+
+// CoinOverrides pins the CoinGecko coin ID of currency symbols, for every project or for a single one.
+// Symbols are matched case-insensitively, project overrides take precedence.
+type CoinOverrides struct {
+	Symbols  map[string]string
+	Projects map[string]map[string]string
+}
+
+// CoinID returns the coin ID the symbol is pinned to in the project, empty if it is not pinned
+func (overrides CoinOverrides) CoinID(projectID, symbol string) string {
+	if id := lookupFold(overrides.Projects[projectID], symbol); id != "" {
+		return id
+	}
+	return lookupFold(overrides.Symbols, symbol)
+}
+
+// lookupFold looks up a symbol case-insensitively
+func lookupFold(ids map[string]string, symbol string) string {
+	if id, ok := ids[symbol]; ok {
+		return id
+	}
+	for key, id := range ids {
+		if strings.EqualFold(key, symbol) {
+			return id
+		}
+	}
+	return ""
+}
+
+// AmbiguousSymbol is a symbol shared by several coins and the coin it was resolved to
+type AmbiguousSymbol struct {
+	Symbol     string
+	Chosen     string
+	Candidates []string
+}
+
+// String describes the ambiguity for logs
+func (ambiguous AmbiguousSymbol) String() string {
+	return fmt.Sprintf("%s -> %s (candidates: %s)", ambiguous.Symbol, ambiguous.Chosen, strings.Join(ambiguous.Candidates, ", "))
+}
+
+// rankCandidates orders the coins sharing a symbol from most to least likely meant:
+// the known canonical coin first, then by market cap rank, then original coins before
+// bridged or wrapped copies, then by shorter and alphabetical ID.
+func rankCandidates(symbol string, candidates []coinListEntry, marketCapRanks map[string]int) []coinListEntry {
+	ranked := append([]coinListEntry(nil), candidates...)
+	known := knownCoinIDs[strings.ToLower(symbol)]
+	rank := func(entry coinListEntry) int {
+		if r, ok := marketCapRanks[entry.ID]; ok && r > 0 {
+			return r
+		}
+		return entry.MarketCapRank
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if (a.ID == known) != (b.ID == known) {
+			return a.ID == known
+		}
+		rankA, rankB := rank(a), rank(b)
+		if rankA != rankB {
+			// unranked coins go last
+			if rankA == 0 || rankB == 0 {
+				return rankB == 0
+			}
+			return rankA < rankB
+		}
+		if isDerivative(a) != isDerivative(b) {
+			return !isDerivative(a)
+		}
+		if len(a.ID) != len(b.ID) {
+			return len(a.ID) < len(b.ID)
+		}
+		return a.ID < b.ID
+	})
+	return ranked
+}
+
+// isDerivative reports whether the coin looks like a bridged, wrapped or pegged copy
+func isDerivative(entry coinListEntry) bool {
+	id, name := strings.ToLower(entry.ID), strings.ToLower(entry.Name)
+	for _, marker := range derivativeMarkers {
+		if strings.Contains(id, marker) || strings.Contains(name, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveCoinIDs resolves the given symbols to coin IDs, reporting the symbols that had several candidates.
+// Symbols without any coin are left out.
+func (geckoClient *CoinGeckoClient) resolveCoinIDs(ctx context.Context, symbolToIds map[string][]coinListEntry, symbols []string) (map[string]string, []AmbiguousSymbol, error) {
+	ids := make(map[string]string, len(symbols))
+	var ambiguousSymbols []string
+	var rankedIDs []string
+	for _, symbol := range symbols {
+		candidates := symbolToIds[strings.ToLower(symbol)]
+		switch {
+		case len(candidates) == 0:
+		case len(candidates) == 1:
+			ids[symbol] = candidates[0].ID
+		default:
+			ambiguousSymbols = append(ambiguousSymbols, symbol)
+			// the market cap is only needed when there is no canonical coin
+			if knownCoinIDs[strings.ToLower(symbol)] == "" {
+				for _, candidate := range candidates {
+					rankedIDs = append(rankedIDs, candidate.ID)
+				}
+			}
+		}
+	}
+
+	var marketCapRanks map[string]int
+	if geckoClient.rankByMarketCap && len(rankedIDs) > 0 {
+		var err error
+		marketCapRanks, err = geckoClient.getMarketCapRanks(ctx, rankedIDs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get market cap ranks: %v", err)
+		}
+	}
+
+	var ambiguous []AmbiguousSymbol
+	for _, symbol := range ambiguousSymbols {
+		ranked := rankCandidates(symbol, symbolToIds[strings.ToLower(symbol)], marketCapRanks)
+		candidates := make([]string, len(ranked))
+		for i, entry := range ranked {
+			candidates[i] = entry.ID
+		}
+		ids[symbol] = ranked[0].ID
+		ambiguous = append(ambiguous, AmbiguousSymbol{Symbol: symbol, Chosen: ranked[0].ID, Candidates: candidates})
+	}
+	return ids, ambiguous, nil
+}
+
+// marketsPageSize is the maximum number of coins returned by a single /coins/markets request
+const marketsPageSize = 250
+
+// getMarketCapRanks returns the market cap rank of the given coins, coins without a rank are left out
+func (geckoClient *CoinGeckoClient) getMarketCapRanks(ctx context.Context, ids []string) (map[string]int, error) {
+	ranks := make(map[string]int, len(ids))
+	for start := 0; start < len(ids); start += marketsPageSize {
+		end := start + marketsPageSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		url := fmt.Sprintf("%s/coins/markets?vs_currency=usd&ids=%s&per_page=%d", geckoClient.baseUrl, strings.Join(ids[start:end], ","), marketsPageSize)
+		resp, err := geckoClient.get(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+
+		var markets []struct {
+			ID            string `json:"id"`
+			MarketCapRank *int   `json:"market_cap_rank"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&markets)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, market := range markets {
+			if market.MarketCapRank != nil {
+				ranks[market.ID] = *market.MarketCapRank
+			}
+		}
+	}
+	return ranks, nil
+}
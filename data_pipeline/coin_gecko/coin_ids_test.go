@@ -0,0 +1,174 @@
+package coingecko
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockAmbiguousTokenIds returns a token list in which several coins share a symbol
+func mockAmbiguousTokenIds(tokenApiListPath string) (map[string][]coinListEntry, error) {
+	return map[string][]coinListEntry{
+		"eth": {
+			{ID: "bridged-ether-starkgate", Symbol: "eth", Name: "Bridged Ether (StarkGate)"},
+			{ID: "ethereum", Symbol: "eth", Name: "Ethereum"},
+		},
+		"gala": {
+			{ID: "gala-bridged", Symbol: "gala", Name: "Bridged GALA"},
+			{ID: "gala-v1", Symbol: "gala", Name: "Gala V1"},
+			{ID: "gala", Symbol: "gala", Name: "GALA"},
+		},
+		"btc": {{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin"}},
+	}, nil
+}
+
+func candidateIDs(entries []coinListEntry) []string {
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	return ids
+}
+
+func TestGetCoinGeckoTokenIds_Collisions(t *testing.T) {
+	symbolToIds, err := getCoinGeckoTokenIds("../../coingecko_token_api_list.csv")
+	assert.NoError(t, err)
+
+	// every coin sharing a symbol is kept instead of the last row winning
+	assert.Greater(t, len(symbolToIds["eth"]), 1)
+	assert.Contains(t, candidateIDs(symbolToIds["eth"]), "ethereum")
+	assert.Equal(t, "ethereum", rankCandidates("ETH", symbolToIds["eth"], nil)[0].ID)
+	assert.Equal(t, "usd-coin", rankCandidates("USDC", symbolToIds["usdc"], nil)[0].ID)
+}
+
+func TestGetCoinGeckoTokenIds_MarketCapRank(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.csv")
+	err := os.WriteFile(path, []byte("Id (API id),Symbol,Name,Market Cap Rank\ngala-v1,GALA,Gala V1,900\ngala,gala,GALA,\n"), 0o644)
+	assert.NoError(t, err)
+
+	symbolToIds, err := getCoinGeckoTokenIds(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []coinListEntry{
+		{ID: "gala-v1", Symbol: "gala", Name: "Gala V1", MarketCapRank: 900},
+		{ID: "gala", Symbol: "gala", Name: "GALA"},
+	}, symbolToIds["gala"])
+}
+
+func TestRankCandidates(t *testing.T) {
+	symbolToIds, _ := mockAmbiguousTokenIds("")
+
+	// the canonical coin of a known symbol wins
+	assert.Equal(t, []string{"ethereum", "bridged-ether-starkgate"}, candidateIDs(rankCandidates("ETH", symbolToIds["eth"], nil)))
+
+	// original coins before bridged copies, then shorter IDs
+	assert.Equal(t, []string{"gala", "gala-v1", "gala-bridged"}, candidateIDs(rankCandidates("GALA", symbolToIds["gala"], nil)))
+
+	// the market cap rank beats the name heuristic
+	ranked := rankCandidates("GALA", symbolToIds["gala"], map[string]int{"gala-v1": 50, "gala-bridged": 3000})
+	assert.Equal(t, []string{"gala-v1", "gala-bridged", "gala"}, candidateIDs(ranked))
+}
+
+func TestCoinOverrides_CoinID(t *testing.T) {
+	overrides := CoinOverrides{
+		Symbols: map[string]string{"usdc": "usd-coin"},
+		Projects: map[string]map[string]string{
+			"4974": {"USDC": "bridged-usdc-polygon-pos-bridge"},
+		},
+	}
+
+	assert.Equal(t, "usd-coin", overrides.CoinID("1", "USDC"))
+	assert.Equal(t, "bridged-usdc-polygon-pos-bridge", overrides.CoinID("4974", "usdc"))
+	assert.Equal(t, "", overrides.CoinID("4974", "ETH"))
+	assert.Equal(t, "", CoinOverrides{}.CoinID("1", "ETH"))
+}
+
+func TestCoinGeckoClient_GetPrices_AmbiguousSymbols(t *testing.T) {
+	var requestedUrls []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedUrls = append(requestedUrls, r.URL.Path)
+		w.Write([]byte(`{"market_data": {"current_price": {"usd": 10}}}`))
+	}))
+	defer mockServer.Close()
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:          mockServer.URL,
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockAmbiguousTokenIds,
+	}
+
+	pinned := models.PriceKey{Symbol: "ETH", Date: "2023-01-01", CoinID: "bridged-ether-starkgate"}
+	_, err := geckoClient.GetPrices(context.TODO(), []models.PriceKey{
+		{Symbol: "ETH", Date: "2023-01-01"},
+		{Symbol: "GALA", Date: "2023-01-01"},
+		{Symbol: "BTC", Date: "2023-01-01"},
+		pinned,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"/coins/ethereum/history",
+		"/coins/gala/history",
+		"/coins/bitcoin/history",
+		"/coins/bridged-ether-starkgate/history",
+	}, requestedUrls)
+
+	// only the symbols resolved by ranking are reported, not the pinned ones
+	assert.Equal(t, []AmbiguousSymbol{
+		{Symbol: "ETH", Chosen: "ethereum", Candidates: []string{"ethereum", "bridged-ether-starkgate"}},
+		{Symbol: "GALA", Chosen: "gala", Candidates: []string{"gala", "gala-v1", "gala-bridged"}},
+	}, geckoClient.AmbiguousSymbols())
+	assert.Equal(t, "GALA -> gala (candidates: gala, gala-v1, gala-bridged)", geckoClient.AmbiguousSymbols()[1].String())
+}
+
+func TestCoinGeckoClient_GetPrices_RankByMarketCap(t *testing.T) {
+	var marketQueries []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/coins/markets" {
+			marketQueries = append(marketQueries, r.URL.Query().Get("ids"))
+			w.Write([]byte(`[{"id": "gala-v1", "market_cap_rank": 50}, {"id": "gala", "market_cap_rank": null}]`))
+			return
+		}
+		w.Write([]byte(`{"market_data": {"current_price": {"usd": 10}}}`))
+	}))
+	defer mockServer.Close()
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:          mockServer.URL,
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockAmbiguousTokenIds,
+		rankByMarketCap:  true,
+	}
+
+	_, err := geckoClient.GetPrices(context.TODO(), []models.PriceKey{
+		{Symbol: "ETH", Date: "2023-01-01"},
+		{Symbol: "GALA", Date: "2023-01-01"},
+	})
+	assert.NoError(t, err)
+	// known symbols need no market cap
+	assert.Equal(t, []string{"gala-bridged,gala-v1,gala"}, marketQueries)
+	assert.Equal(t, "gala-v1", geckoClient.AmbiguousSymbols()[1].Chosen)
+}
+
+func TestCoinGeckoClient_UnknownSymbols(t *testing.T) {
+	geckoClient := &CoinGeckoClient{
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockAmbiguousTokenIds,
+	}
+
+	unknown, contracts, err := geckoClient.UnknownSymbols(context.TODO(), []models.PriceKey{
+		{Symbol: "ZZZ", Date: "2023-01-01"},
+		{Symbol: "eth", Date: "2023-01-01"},
+		{Symbol: "FOO", Date: "2023-01-01"},
+		{Symbol: "ZZZ", Date: "2023-01-02"},
+		// pinned symbols need no token list entry
+		{Symbol: "BAR", Date: "2023-01-01", CoinID: "bar-coin"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"FOO", "ZZZ"}, unknown)
+	assert.Empty(t, contracts)
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/0xivanov/blockchain-data-aggregator/models"
@@ -21,84 +23,617 @@ type MarketData struct {
 	CurrentPrice map[string]float64 `json:"current_price"`
 }
 
+// ClientOptions configures how hard the CoinGecko API is hit
+type ClientOptions struct {
+	// Plan decides the endpoint, the API key header and the rate limit.
+	// When empty it is PlanDemo with an API key and PlanPublic without one.
+	Plan Plan
+	// RequestsPerMinute overrides the rate limit of the plan when positive
+	RequestsPerMinute int
+	// Workers is the number of prices fetched in parallel, one when zero
+	Workers int
+	// MaxRetries is the number of times a throttled or failed request is retried
+	MaxRetries int
+	// Cache is consulted before any request and filled with the fetched prices, no caching when nil
+	Cache *PriceCache
+	// TokenListDir holds the versions of the token list downloaded by RefreshTokenList. The latest version
+	// is used instead of the bundled token list when there is one.
+	TokenListDir string
+	// ChainPlatforms maps chain names onto CoinGecko asset platform IDs, e.g. "polygon" -> "polygon-pos".
+	// Unmapped chains are used as platform IDs as they are.
+	ChainPlatforms map[string]string
+	// RankByMarketCap ranks the coins sharing a symbol by their current market cap, which costs extra requests
+	RankByMarketCap bool
+	// Pegs prices stablecoins and wrapped assets without asking for the price of their own coin, keyed by symbol
+	Pegs map[string]Peg
+	// DepegThreshold is the relative deviation from the peg a sampled stablecoin price is reported at, e.g. 0.02.
+	// No price is sampled when zero.
+	DepegThreshold float64
+	// DepegSamples is the number of days the real price of every stablecoin is sampled on, one when zero
+	DepegSamples int
+}
+
 // CoinGeckoClient handles the communication with the CoinGecko API
 type CoinGeckoClient struct {
-	apiKey  string
-	baseUrl string
+	apiKey string
+	// apiKeyHeader carries the API key, the key is not sent when empty
+	apiKeyHeader string
+	baseUrl      string
 	// this is the path to the file containing the official list of token IDs for the CoinGecko API
 	tokenApiListPath string
 	// injected function for testing purposes
-	getTokenIdsFunc func(filePathtokenApiListPath string) (map[string]string, error)
+	getTokenIdsFunc func(filePathtokenApiListPath string) (map[string][]coinListEntry, error)
+	// limiter spaces out the requests, no limit when nil
+	limiter *tokenBucket
+	// maximum number of prices fetched in parallel
+	workers int
+	// retries of throttled or failed requests, with exponential backoff between minBackoff and maxBackoff
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	// injected function for testing purposes
+	sleep func(ctx context.Context, d time.Duration) error
+	// cache of historical prices, no caching when nil
+	cache *PriceCache
+	// whether the coins sharing a symbol are ranked by market cap
+	rankByMarketCap bool
+	// chain names mapped onto asset platform IDs
+	chainPlatforms map[string]string
+	// contracts asked from the API so far, with an empty ID when CoinGecko does not know them
+	contractIDs map[contractKey]string
+	// pegs keyed by uppercase symbol, and the sampling of the real prices of the stablecoins
+	pegs           map[string]Peg
+	depegThreshold float64
+	depegSamples   int
+	// stablecoins which deviated from their peg in the last call of GetPrices
+	depegs []Depeg
+	// symbols shared by several coins seen in the last call of GetPrices
+	ambiguous []AmbiguousSymbol
+	// provenance of the prices of the last call of GetPrices
+	provenance map[models.PriceKey]models.PriceProvenance
 }
 
-func NewCoinGeckoClient(apiKey, tokenApiListPath string) *CoinGeckoClient {
+func NewCoinGeckoClient(apiKey, tokenApiListPath string, options ClientOptions) (*CoinGeckoClient, error) {
+	plan, err := planFor(options.Plan, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	rateLimit, err := plan.RateLimit()
+	if err != nil {
+		return nil, err
+	}
+	if options.RequestsPerMinute > 0 {
+		rateLimit = options.RequestsPerMinute
+	}
+	// prefer the latest downloaded token list over the bundled one
+	latest, err := LatestTokenList(options.TokenListDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the latest token list: %v", err)
+	}
+	if latest != "" {
+		tokenApiListPath = latest
+	}
+
+	workers := options.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	pegs, err := normalizePegs(options.Pegs)
+	if err != nil {
+		return nil, err
+	}
+	depegSamples := options.DepegSamples
+	if depegSamples < 1 {
+		depegSamples = 1
+	}
+
 	return &CoinGeckoClient{
 		apiKey:           apiKey,
+		apiKeyHeader:     plan.apiKeyHeader(),
 		tokenApiListPath: tokenApiListPath,
-		// use https://pro-api.coingecko.com/api/v3 for pro api keys
-		baseUrl:         "https://api.coingecko.com/api/v3",
-		getTokenIdsFunc: getCoinGeckoTokenIds,
+		baseUrl:          plan.BaseUrl(),
+		getTokenIdsFunc:  getCoinGeckoTokenIds,
+		limiter:          newTokenBucket(rateLimit, workers),
+		workers:          workers,
+		maxRetries:       options.MaxRetries,
+		minBackoff:       time.Second,
+		maxBackoff:       time.Minute,
+		sleep:            sleepContext,
+		cache:            options.Cache,
+		rankByMarketCap:  options.RankByMarketCap,
+		chainPlatforms:   options.ChainPlatforms,
+		pegs:             pegs,
+		depegThreshold:   options.DepegThreshold,
+		depegSamples:     depegSamples,
+	}, nil
+}
+
+// GetPriceMap returns the USD prices of the currencies of the given transactions on the day of each transaction
+// in the location, the reporting time zone of the aggregator, UTC when nil
+func (geckoClient *CoinGeckoClient) GetPriceMap(ctx context.Context, transactions []models.Transaction, location *time.Location) (models.PriceMap, error) {
+	if location == nil {
+		location = time.UTC
+	}
+	// every (symbol, day) pair is priced once, no matter how many transactions share it
+	seen := make(map[models.PriceKey]bool)
+	var keys []models.PriceKey
+	for _, txn := range transactions {
+		key := models.PriceKey{
+			Symbol:   txn.CurrencySymbol,
+			Date:     txn.Date.In(location).Format(time.DateOnly),
+			CoinID:   txn.CoinID,
+			Chain:    txn.Chain,
+			Contract: txn.ContractAddress,
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
 	}
+	return geckoClient.GetPrices(ctx, keys)
+}
+
+// Name returns the name of the price provider
+func (geckoClient *CoinGeckoClient) Name() string {
+	return "coingecko"
+}
+
+// TokenListPath returns the path of the token list the client resolves symbols with
+func (geckoClient *CoinGeckoClient) TokenListPath() string {
+	return geckoClient.tokenApiListPath
 }
 
-// GetPriceMap returns a map of currency symbols to their respective prices in USD at the given date
-func (geckoClient *CoinGeckoClient) GetPriceMap(ctx context.Context, transactions []models.Transaction) (map[string]float64, error) {
+// AmbiguousSymbols returns the symbols shared by several coins which were resolved in the last call of GetPrices
+func (geckoClient *CoinGeckoClient) AmbiguousSymbols() []AmbiguousSymbol {
+	return geckoClient.ambiguous
+}
+
+// Depegs returns the sampled stablecoin prices which deviated from their peg in the last call of GetPrices
+func (geckoClient *CoinGeckoClient) Depegs() []Depeg {
+	return geckoClient.depegs
+}
+
+// Provenance returns the coin, the fetch time and whether the cache was hit for every price of the last call of GetPrices.
+// Stablecoins priced at their peg are attributed to the coingecko/peg provider.
+func (geckoClient *CoinGeckoClient) Provenance() map[models.PriceKey]models.PriceProvenance {
+	return geckoClient.provenance
+}
+
+// UnknownSymbols resolves the token contracts of the keys and returns the sorted symbols which are not in the token
+// list and the contracts CoinGecko does not know, so they can be reported before the prices are fetched.
+// The keys of unknown contracts are priced by their symbol, so their symbol is unknown when it is not in the list
+// either. The contracts are returned as keys without a day. Keys pinned to a coin ID or pegged are never unknown.
+func (geckoClient *CoinGeckoClient) UnknownSymbols(ctx context.Context, keys []models.PriceKey) ([]string, []models.PriceKey, error) {
+	symbolToIds, err := geckoClient.getTokenIdsFunc(geckoClient.tokenApiListPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get token IDs: %v", err)
+	}
+	contractIDs, err := geckoClient.resolveContracts(ctx, symbolToIds, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := make(map[string]bool)
+	seenContracts := make(map[models.PriceKey]bool)
+	var unknown []string
+	var unknownContracts []models.PriceKey
+	for _, key := range keys {
+		if key.CoinID != "" {
+			continue
+		}
+		if _, ok := geckoClient.pegOf(key); ok {
+			continue
+		}
+		if contract, ok := geckoClient.contractOf(key); ok {
+			if contractIDs[contract] != "" {
+				continue
+			}
+			currency := models.PriceKey{Symbol: key.Symbol, Chain: key.Chain, Contract: key.Contract}
+			if !seenContracts[currency] {
+				seenContracts[currency] = true
+				unknownContracts = append(unknownContracts, currency)
+			}
+		}
+		if seen[key.Symbol] {
+			continue
+		}
+		seen[key.Symbol] = true
+		if len(symbolToIds[strings.ToLower(key.Symbol)]) == 0 {
+			unknown = append(unknown, key.Symbol)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown, unknownContracts, nil
+}
+
+// GetPrices returns the USD price of every requested currency symbol on the requested day,
+// or at the sample nearest to the requested time for keys with a Time.
+// Stablecoins are priced at their USD peg without a request and wrapped assets by their underlying coin.
+// Keys pinned to a coin ID are priced by that coin, keys with a token contract by the coin of the contract,
+// the others and contracts unknown to CoinGecko by the best ranked coin of their symbol.
+// Symbols without a CoinGecko token ID are left out, on error the prices fetched so far are returned with it.
+func (geckoClient *CoinGeckoClient) GetPrices(ctx context.Context, keys []models.PriceKey) (models.PriceMap, error) {
+	prices := make(models.PriceMap, len(keys))
+	geckoClient.provenance = make(map[models.PriceKey]models.PriceProvenance, len(keys))
+
+	// every key is fetched by the key of the coin it is priced by, stablecoins priced in USD are not fetched at all
+	fetchedBy := make(map[models.PriceKey]models.PriceKey, len(keys))
+	var fetchKeys []models.PriceKey
+	queued := make(map[models.PriceKey]bool)
+	fetch := func(key, by models.PriceKey) {
+		fetchedBy[key] = by
+		if !queued[by] {
+			queued[by] = true
+			fetchKeys = append(fetchKeys, by)
+		}
+	}
+	var stablecoins []string
+	pegged := make(map[string][]models.PriceKey)
+	for _, key := range keys {
+		peg, ok := geckoClient.pegOf(key)
+		switch {
+		case !ok:
+			fetch(key, key)
+		case peg.CoinID != "":
+			fetch(key, underlyingKey(key, peg))
+		case key.Fiat == "":
+			prices[key] = peg.Price
+			geckoClient.provenance[key] = models.PriceProvenance{Provider: "coingecko/peg"}
+			symbol := strings.ToUpper(key.Symbol)
+			if _, ok := pegged[symbol]; !ok {
+				stablecoins = append(stablecoins, symbol)
+			}
+			pegged[symbol] = append(pegged[symbol], key)
+		default:
+			// stablecoins are only pegged to USD
+			fetch(key, key)
+		}
+	}
+
+	// the samples are fetched first, so the ambiguous symbols are the ones resolved for the prices
+	geckoClient.depegs = nil
+	if geckoClient.depegThreshold > 0 {
+		geckoClient.depegs = geckoClient.sampleDepegs(ctx, depegSamples(pegged, stablecoins, geckoClient.depegSamples), prices)
+	}
+
+	provenance := make(map[models.PriceKey]models.PriceProvenance, len(fetchKeys))
+	fetched, err := geckoClient.fetchPrices(ctx, fetchKeys, provenance)
+	for key, by := range fetchedBy {
+		if price, ok := fetched[by]; ok {
+			prices[key] = price
+			geckoClient.provenance[key] = provenance[by]
+		}
+	}
+	return prices, err
+}
+
+// sampleDepegs fetches the real prices of the sampled stablecoins and returns the ones deviating from their peg
+// in the prices beyond the threshold. The samples are best effort, the ones which fail are not checked and
+// do not fail the prices.
+func (geckoClient *CoinGeckoClient) sampleDepegs(ctx context.Context, samples []models.PriceKey, prices models.PriceMap) []Depeg {
+	if len(samples) == 0 {
+		return nil
+	}
+	sampled, _ := geckoClient.fetchPrices(ctx, samples, make(map[models.PriceKey]models.PriceProvenance, len(samples)))
+	var depegs []Depeg
+	for _, sample := range samples {
+		price, ok := sampled[sample]
+		if !ok {
+			continue
+		}
+		depeg := Depeg{Symbol: sample.Symbol, Date: sample.Date, Peg: prices[sample], Price: price}
+		if depeg.Deviation() > geckoClient.depegThreshold {
+			depegs = append(depegs, depeg)
+		}
+	}
+	return depegs
+}
+
+// fetchPrices resolves the coins of the keys and fetches their prices from the cache or the API,
+// filling in the provenance of every price found
+func (geckoClient *CoinGeckoClient) fetchPrices(ctx context.Context, keys []models.PriceKey, provenance map[models.PriceKey]models.PriceProvenance) (models.PriceMap, error) {
 	// get the token IDs for the given currency symbols
-	symbolToIdMap, err := geckoClient.getTokenIdsFunc(geckoClient.tokenApiListPath)
+	symbolToIds, err := geckoClient.getTokenIdsFunc(geckoClient.tokenApiListPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get token IDs: %v", err)
 	}
 
-	// prices holds symbol -> price mappings
-	prices := make(map[string]float64)
-	for _, txn := range transactions {
+	// resolve the token contracts first, the symbol is only a fallback for them
+	contractIDs, err := geckoClient.resolveContracts(ctx, symbolToIds, keys)
+	if err != nil {
+		return nil, err
+	}
+	contractID := func(key models.PriceKey) string {
+		contract, ok := geckoClient.contractOf(key)
+		if !ok {
+			return ""
+		}
+		return contractIDs[contract]
+	}
 
-		// skip if the price is already fetched
-		if prices[txn.CurrencySymbol] != 0 {
-			continue
+	// resolve the symbols which are neither pinned to a coin nor resolved by their contract
+	var symbols []string
+	seenSymbols := make(map[string]bool)
+	for _, key := range keys {
+		if key.CoinID == "" && contractID(key) == "" && !seenSymbols[key.Symbol] {
+			seenSymbols[key.Symbol] = true
+			symbols = append(symbols, key.Symbol)
 		}
+	}
+	symbolToId, ambiguous, err := geckoClient.resolveCoinIDs(ctx, symbolToIds, symbols)
+	if err != nil {
+		return nil, err
+	}
+	geckoClient.ambiguous = ambiguous
 
-		// get the token ID for the currency symbol since the CoinGecko API uses token IDs
-		// and convert the symbol to lowercase to match the map keys
-		symbol := symbolToIdMap[strings.ToLower(txn.CurrencySymbol)]
-		// fetch the historical prices via the CoinGecko API
-		price, err := geckoClient.getPriceInUsd(ctx, symbol, txn.Date)
+	// validate the keys and drop duplicates before any request is sent. The keys of a coin on the same day
+	// share a request, as the history holds the price in every fiat currency.
+	var intraday []models.PriceKey
+	var days []*dayPrices
+	byDay := make(map[string]*dayPrices)
+	coinIDs := make(map[models.PriceKey]string, len(keys))
+	for _, key := range keys {
+		if _, ok := coinIDs[key]; ok {
+			continue
+		}
+		coinID := key.CoinID
+		if coinID == "" {
+			coinID = contractID(key)
+		}
+		if coinID == "" {
+			coinID = symbolToId[key.Symbol]
+		}
+		if coinID == "" {
+			continue
+		}
+		date, err := time.Parse(time.DateOnly, key.Date)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get price for %s: %v", txn.CurrencySymbol, err)
+			return nil, fmt.Errorf("invalid price date %q for %s: %v", key.Date, key.Symbol, err)
+		}
+		coinIDs[key] = coinID
+		if key.Time != 0 {
+			intraday = append(intraday, key)
+			continue
 		}
-		prices[txn.CurrencySymbol] = price
+		day, ok := byDay[coinID+"/"+key.Date]
+		if !ok {
+			day = &dayPrices{coinID: coinID, date: date}
+			byDay[coinID+"/"+key.Date] = day
+			days = append(days, day)
+		}
+		day.keys = append(day.keys, key)
+	}
+
+	// intraday prices come from a single chart per coin, the daily ones are fetched one by one
+	prices, err := geckoClient.getIntradayPrices(ctx, intraday, coinIDs, provenance)
+	if err != nil {
+		return prices, err
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// fetch the prices with a bounded pool of workers, the first failure stops the others
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	jobs := make(chan *dayPrices)
+	workers := geckoClient.workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for day := range jobs {
+				// fetch the historical prices via the cache or the CoinGecko API, which uses token IDs
+				fiatPrices, source, err := geckoClient.getCachedFiatPrices(ctx, day.coinID, day.date, day.fiats())
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("failed to get price for %s on %s: %v", day.keys[0].Symbol, day.keys[0].Date, err)
+					cancel()
+				} else if err == nil {
+					// fiat currencies missing from the history are left out
+					for _, key := range day.keys {
+						if price, ok := fiatPrices[fiatOf(key)]; ok {
+							prices[key] = price
+							provenance[key] = source
+						}
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, day := range days {
+		select {
+		case jobs <- day:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return prices, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return prices, err
+	}
 	return prices, nil
 }
 
-func (geckoClient *CoinGeckoClient) getPriceInUsd(ctx context.Context, symbol string, date time.Time) (float64, error) {
-	parsedDate := date.Format(geckoDateFormat)
+// dayPrices holds the keys priced by the history of a coin on a single day
+type dayPrices struct {
+	coinID string
+	date   time.Time
+	keys   []models.PriceKey
+}
+
+// fiats returns the distinct fiat currencies the keys are priced in
+func (day *dayPrices) fiats() []string {
+	var fiats []string
+	seen := make(map[string]bool)
+	for _, key := range day.keys {
+		if fiat := fiatOf(key); !seen[fiat] {
+			seen[fiat] = true
+			fiats = append(fiats, fiat)
+		}
+	}
+	return fiats
+}
+
+// fiatOf returns the lowercase code of the fiat currency the key is priced in
+func fiatOf(key models.PriceKey) string {
+	if key.Fiat == "" {
+		return "usd"
+	}
+	return strings.ToLower(key.Fiat)
+}
 
-	url := fmt.Sprintf("%s/coins/%s/history?date=%s?localization=false", geckoClient.baseUrl, symbol, parsedDate)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil) // Create a new request with context
+// getCachedFiatPrices returns the cached prices of the coin on the given day in the fiat currencies and where
+// they came from, fetching the history and caching every requested currency on a miss
+func (geckoClient *CoinGeckoClient) getCachedFiatPrices(ctx context.Context, coinID string, date time.Time, fiats []string) (map[string]float64, models.PriceProvenance, error) {
+	source := models.PriceProvenance{CoinID: coinID}
+	if geckoClient.cache == nil {
+		prices, err := geckoClient.getFiatPrices(ctx, coinID, date)
+		source.FetchedAt = time.Now().UTC()
+		return prices, source, err
+	}
+
+	// the currencies of a day are cached together, they count as a single lookup
+	keys := make([]CacheKey, len(fiats))
+	for i, fiat := range fiats {
+		keys[i] = CacheKey{Provider: "coingecko", CoinID: coinID, Date: date.Format(time.DateOnly), Fiat: fiat}
+	}
+	entries, ok, err := geckoClient.cache.LookupAll(keys)
 	if err != nil {
-		return 0, err
+		return nil, source, fmt.Errorf("failed to read price cache: %v", err)
+	}
+	if ok {
+		cached := make(map[string]float64, len(fiats))
+		for i, fiat := range fiats {
+			cached[fiat] = entries[i].Price
+		}
+		source.FetchedAt = entries[0].FetchedAt
+		source.CacheHit = true
+		return cached, source, nil
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	prices, err := geckoClient.getFiatPrices(ctx, coinID, date)
 	if err != nil {
-		return 0, err
+		return nil, source, err
+	}
+	source.FetchedAt = time.Now().UTC()
+	for _, fiat := range fiats {
+		price, ok := prices[fiat]
+		if !ok {
+			continue
+		}
+		key := CacheKey{Provider: "coingecko", CoinID: coinID, Date: date.Format(time.DateOnly), Fiat: fiat}
+		if err := geckoClient.cache.Put(key, price); err != nil {
+			return nil, source, fmt.Errorf("failed to write price cache: %v", err)
+		}
 	}
+	return prices, source, nil
+}
+
+// getFiatPrices returns the prices of the coin on the given day in every fiat currency of the history,
+// keyed by lowercase currency code. The USD price is required.
+func (geckoClient *CoinGeckoClient) getFiatPrices(ctx context.Context, coinID string, date time.Time) (map[string]float64, error) {
+	parsedDate := date.Format(geckoDateFormat)
 
-	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("request failed with status: %v", resp.Status)
+	url := fmt.Sprintf("%s/coins/%s/history?date=%s&localization=false", geckoClient.baseUrl, coinID, parsedDate)
+	resp, err := geckoClient.get(ctx, url)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	var result CoinGeckoResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	if result.MarketData.CurrentPrice["usd"] == 0 {
+		return nil, fmt.Errorf("price not found in response")
 	}
+	return result.MarketData.CurrentPrice, nil
+}
 
-	price := result.MarketData.CurrentPrice["usd"]
-	if price == 0 {
-		return 0, fmt.Errorf("price not found in response")
+// statusError is returned for requests answered with a status other than 200 OK
+type statusError struct {
+	statusCode int
+	status     string
+}
+
+func (err *statusError) Error() string {
+	return fmt.Sprintf("request failed with status: %v", err.status)
+}
+
+// httpClient sends the requests of every client. The timeout covers reading the body as well, so a stalled
+// connection fails and is retried instead of hanging the run.
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// get sends a rate limited GET request, retrying throttled and failed requests with backoff.
+// A Retry-After header sent by the API takes precedence over the backoff.
+func (geckoClient *CoinGeckoClient) get(ctx context.Context, url string) (*http.Response, error) {
+	sleep := geckoClient.sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	for attempt := 0; ; attempt++ {
+		if geckoClient.limiter != nil {
+			if err := geckoClient.limiter.wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil) // Create a new request with context
+		if err != nil {
+			return nil, err
+		}
+		if geckoClient.apiKey != "" && geckoClient.apiKeyHeader != "" {
+			req.Header.Set(geckoClient.apiKeyHeader, geckoClient.apiKey)
+		}
+
+		delay := backoff(attempt, geckoClient.minBackoff, geckoClient.maxBackoff)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			// a cancelled run is not retried
+			if ctx.Err() != nil || attempt >= geckoClient.maxRetries {
+				return nil, err
+			}
+		} else {
+			if resp.StatusCode == http.StatusOK {
+				return resp, nil
+			}
+			resp.Body.Close()
+			err = &statusError{statusCode: resp.StatusCode, status: resp.Status}
+			if !isRetryable(resp.StatusCode) {
+				return nil, err
+			}
+			if attempt >= geckoClient.maxRetries {
+				if attempt > 0 {
+					return nil, fmt.Errorf("%v, giving up after %d retries", err, attempt)
+				}
+				return nil, err
+			}
+			if requested, ok := retryAfter(resp, time.Now()); ok {
+				delay = requested
+			}
+		}
+
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
 	}
-	return price, nil
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -12,11 +13,11 @@ import (
 )
 
 // Mock for getCoinGeckoTokenIds
-func mockGetCoinGeckoTokenIds(tokenApiListPath string) (map[string]string, error) {
+func mockGetCoinGeckoTokenIds(tokenApiListPath string) (map[string][]coinListEntry, error) {
 	// returns a mock mapping of currency symbols to their CoinGecko token IDs
-	return map[string]string{
-		"eth": "ethereum",
-		"btc": "bitcoin",
+	return map[string][]coinListEntry{
+		"eth": {{ID: "ethereum", Symbol: "eth", Name: "Ethereum"}},
+		"btc": {{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin"}},
 	}, nil
 }
 
@@ -57,22 +58,40 @@ func TestCoinGeckoClient_GetPriceMap(t *testing.T) {
 		},
 	}
 
-	priceMap, err := geckoClient.GetPriceMap(context.TODO(), transactions)
+	priceMap, err := geckoClient.GetPriceMap(context.TODO(), transactions, nil)
 	assert.NoError(t, err)
 
-	assert.Equal(t, 2000.5, priceMap["ETH"])
-	assert.Equal(t, 2000.5, priceMap["BTC"])
+	assert.Equal(t, 2000.5, priceMap[models.PriceKey{Symbol: "ETH", Date: "2023-01-01"}])
+	assert.Equal(t, 2000.5, priceMap[models.PriceKey{Symbol: "BTC", Date: "2023-01-02"}])
 }
 
-func TestCoinGeckoClient_GetPriceMap_ReusePrice(t *testing.T) {
-	mockResponse := `{
-		"market_data": {
-			"current_price": {
-				"usd": 2000.5
-			}
-		}
-	}`
-	mockServer := setupMockServer(mockResponse, http.StatusOK)
+func TestCoinGeckoClient_GetPriceMap_ReportingZone(t *testing.T) {
+	mockServer := setupMockServer(`{"market_data": {"current_price": {"usd": 2000.5}}}`, http.StatusOK)
+	defer mockServer.Close()
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:          mockServer.URL,
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockGetCoinGeckoTokenIds,
+	}
+
+	// late on the 1st in UTC is the 2nd in the reporting zone
+	transactions := []models.Transaction{{CurrencySymbol: "ETH", Date: time.Date(2023, 1, 1, 23, 0, 0, 0, time.UTC)}}
+	priceMap, err := geckoClient.GetPriceMap(context.TODO(), transactions, time.FixedZone("UTC+2", 2*60*60))
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{{Symbol: "ETH", Date: "2023-01-02"}: 2000.5}, priceMap)
+}
+
+func TestCoinGeckoClient_GetPriceMap_PricePerDay(t *testing.T) {
+	var requestedDates []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the date is passed as DD-MM-YYYY, without the localized names
+		assert.Equal(t, "false", r.URL.Query().Get("localization"))
+		date := r.URL.Query().Get("date")
+		requestedDates = append(requestedDates, date)
+		price := map[string]string{"01-01-2023": "1000", "02-01-2023": "2000"}[date]
+		w.Write([]byte(`{"market_data": {"current_price": {"usd": ` + price + `}}}`))
+	}))
 	defer mockServer.Close()
 
 	geckoClient := &CoinGeckoClient{
@@ -84,7 +103,11 @@ func TestCoinGeckoClient_GetPriceMap_ReusePrice(t *testing.T) {
 	transactions := []models.Transaction{
 		{
 			CurrencySymbol: "ETH",
-			Date:           time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			Date:           time.Date(2023, 1, 1, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			CurrencySymbol: "ETH",
+			Date:           time.Date(2023, 1, 1, 20, 0, 0, 0, time.UTC),
 		},
 		{
 			CurrencySymbol: "ETH",
@@ -92,12 +115,17 @@ func TestCoinGeckoClient_GetPriceMap_ReusePrice(t *testing.T) {
 		},
 	}
 
-	priceMap, err := geckoClient.GetPriceMap(context.TODO(), transactions)
+	priceMap, err := geckoClient.GetPriceMap(context.TODO(), transactions, nil)
 	assert.NoError(t, err)
-	assert.Equal(t, 2000.5, priceMap["ETH"])
+	assert.Equal(t, models.PriceMap{
+		{Symbol: "ETH", Date: "2023-01-01"}: 1000,
+		{Symbol: "ETH", Date: "2023-01-02"}: 2000,
+	}, priceMap)
+	// transactions on the same day share a single request
+	assert.Equal(t, []string{"01-01-2023", "02-01-2023"}, requestedDates)
 }
 
-func TestCoinGeckoClient_GetPriceInUsd(t *testing.T) {
+func TestCoinGeckoClient_GetFiatPrices(t *testing.T) {
 	mockResponse := `{
 		"market_data": {
 			"current_price": {
@@ -113,12 +141,51 @@ func TestCoinGeckoClient_GetPriceInUsd(t *testing.T) {
 	}
 
 	date := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
-	price, err := geckoClient.getPriceInUsd(context.TODO(), "ethereum", date)
+	prices, err := geckoClient.getFiatPrices(context.TODO(), "ethereum", date)
+	assert.NoError(t, err)
+	assert.Equal(t, 1500.75, prices["usd"])
+}
+
+func TestCoinGeckoClient_ApiKeyHeader(t *testing.T) {
+	var headers http.Header
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		w.Write([]byte(`{"market_data": {"current_price": {"usd": 1}}}`))
+	}))
+	defer mockServer.Close()
+
+	tests := []struct {
+		plan    Plan
+		header  string
+		baseUrl string
+	}{
+		{"", "x-cg-demo-api-key", "https://api.coingecko.com/api/v3"},
+		{PlanDemo, "x-cg-demo-api-key", "https://api.coingecko.com/api/v3"},
+		{PlanAnalyst, "x-cg-pro-api-key", "https://pro-api.coingecko.com/api/v3"},
+		{PlanPro, "x-cg-pro-api-key", "https://pro-api.coingecko.com/api/v3"},
+	}
+	for _, test := range tests {
+		geckoClient, err := NewCoinGeckoClient("secret", "", ClientOptions{Plan: test.plan})
+		assert.NoError(t, err)
+		assert.Equal(t, test.baseUrl, geckoClient.baseUrl)
+
+		geckoClient.baseUrl = mockServer.URL
+		_, err = geckoClient.getFiatPrices(context.TODO(), "ethereum", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+		assert.NoError(t, err)
+		assert.Equal(t, "secret", headers.Get(test.header), test.plan)
+	}
+
+	// the public API is called without a key
+	geckoClient, err := NewCoinGeckoClient("", "", ClientOptions{})
+	assert.NoError(t, err)
+	geckoClient.baseUrl = mockServer.URL
+	_, err = geckoClient.getFiatPrices(context.TODO(), "ethereum", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
 	assert.NoError(t, err)
-	assert.Equal(t, 1500.75, price)
+	assert.Empty(t, headers.Get("x-cg-demo-api-key"))
+	assert.Empty(t, headers.Get("x-cg-pro-api-key"))
 }
 
-func TestCoinGeckoClient_GetPriceInUsd_MalformedResponse(t *testing.T) {
+func TestCoinGeckoClient_GetFiatPrices_MalformedResponse(t *testing.T) {
 	mockResponse := `{
 		"market_data": {
 			"current_price": {
@@ -134,11 +201,11 @@ func TestCoinGeckoClient_GetPriceInUsd_MalformedResponse(t *testing.T) {
 	}
 
 	date := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
-	_, err := geckoClient.getPriceInUsd(context.TODO(), "ethereum", date)
+	_, err := geckoClient.getFiatPrices(context.TODO(), "ethereum", date)
 	assert.Error(t, err)
 }
 
-func TestCoinGeckoClient_GetPriceInUsd_EmptyResp(t *testing.T) {
+func TestCoinGeckoClient_GetFiatPrices_EmptyResp(t *testing.T) {
 	mockServer := setupMockServer("{}", http.StatusOK)
 	defer mockServer.Close()
 
@@ -147,11 +214,11 @@ func TestCoinGeckoClient_GetPriceInUsd_EmptyResp(t *testing.T) {
 	}
 
 	date := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
-	_, err := geckoClient.getPriceInUsd(context.TODO(), "ethereum", date)
+	_, err := geckoClient.getFiatPrices(context.TODO(), "ethereum", date)
 	assert.Error(t, err)
 }
 
-func TestCoinGeckoClient_GetPriceInUsd_ApiError(t *testing.T) {
+func TestCoinGeckoClient_GetFiatPrices_ApiError(t *testing.T) {
 	mockServer := setupMockServer("{}", http.StatusInternalServerError)
 	defer mockServer.Close()
 
@@ -160,6 +227,113 @@ func TestCoinGeckoClient_GetPriceInUsd_ApiError(t *testing.T) {
 	}
 
 	date := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
-	_, err := geckoClient.getPriceInUsd(context.TODO(), "ethereum", date)
+	_, err := geckoClient.getFiatPrices(context.TODO(), "ethereum", date)
 	assert.ErrorContains(t, err, "request failed with status")
 }
+
+func TestCoinGeckoClient_GetPrices_DistinctKeys(t *testing.T) {
+	var requestedUrls []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedUrls = append(requestedUrls, r.URL.Path)
+		w.Write([]byte(`{"market_data": {"current_price": {"usd": 10}}}`))
+	}))
+	defer mockServer.Close()
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:          mockServer.URL,
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockGetCoinGeckoTokenIds,
+	}
+
+	keys := []models.PriceKey{
+		{Symbol: "ETH", Date: "2023-01-01"},
+		{Symbol: "BTC", Date: "2023-01-01"},
+		{Symbol: "ETH", Date: "2023-01-01"},
+	}
+
+	priceMap, err := geckoClient.GetPrices(context.TODO(), keys)
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{
+		{Symbol: "ETH", Date: "2023-01-01"}: 10,
+		{Symbol: "BTC", Date: "2023-01-01"}: 10,
+	}, priceMap)
+	assert.Equal(t, []string{"/coins/ethereum/history", "/coins/bitcoin/history"}, requestedUrls)
+}
+
+func TestCoinGeckoClient_GetPrices_InvalidDate(t *testing.T) {
+	geckoClient := &CoinGeckoClient{
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockGetCoinGeckoTokenIds,
+	}
+
+	_, err := geckoClient.GetPrices(context.TODO(), []models.PriceKey{{Symbol: "ETH", Date: "01-01-2023"}})
+	assert.ErrorContains(t, err, "invalid price date")
+}
+
+func TestCoinGeckoClient_GetPrices_UnknownSymbol(t *testing.T) {
+	var requestedUrls []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedUrls = append(requestedUrls, r.URL.Path)
+		w.Write([]byte(`{"market_data": {"current_price": {"usd": 10}}}`))
+	}))
+	defer mockServer.Close()
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:          mockServer.URL,
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockGetCoinGeckoTokenIds,
+	}
+
+	// symbols without a token ID are left to other providers
+	priceMap, err := geckoClient.GetPrices(context.TODO(), []models.PriceKey{
+		{Symbol: "ETH", Date: "2023-01-01"},
+		{Symbol: "NOPE", Date: "2023-01-01"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{{Symbol: "ETH", Date: "2023-01-01"}: 10}, priceMap)
+	assert.Equal(t, []string{"/coins/ethereum/history"}, requestedUrls)
+}
+
+func TestCoinGeckoClient_GetPrices_FiatCurrencies(t *testing.T) {
+	var requests int
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"market_data": {"current_price": {"usd": 3000, "eur": 2800, "gbp": 2400}}}`))
+	}))
+	defer mockServer.Close()
+
+	cache, err := OpenPriceCache(filepath.Join(t.TempDir(), "prices.db"))
+	assert.NoError(t, err)
+	defer cache.Close()
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:          mockServer.URL,
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockGetCoinGeckoTokenIds,
+		cache:            cache,
+	}
+
+	usd := models.PriceKey{Symbol: "ETH", Date: "2023-01-01"}
+	eur := models.PriceKey{Symbol: "ETH", Date: "2023-01-01", Fiat: "eur"}
+	// currencies missing from the history are left out
+	chf := models.PriceKey{Symbol: "ETH", Date: "2023-01-01", Fiat: "chf"}
+	priceMap, err := geckoClient.GetPrices(context.TODO(), []models.PriceKey{usd, eur, chf})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{usd: 3000, eur: 2800}, priceMap)
+	// every currency comes from the same response
+	assert.Equal(t, 1, requests)
+
+	// the cached currencies are served without a request
+	priceMap, err = geckoClient.GetPrices(context.TODO(), []models.PriceKey{usd, eur})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{usd: 3000, eur: 2800}, priceMap)
+	assert.Equal(t, 1, requests)
+
+	// a missing currency fetches the day again, which counts as a single miss
+	gbp := models.PriceKey{Symbol: "ETH", Date: "2023-01-01", Fiat: "gbp"}
+	priceMap, err = geckoClient.GetPrices(context.TODO(), []models.PriceKey{usd, gbp})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{usd: 3000, gbp: 2400}, priceMap)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 2}, cache.Stats())
+}
@@ -3,10 +3,38 @@ package coingecko
 import (
 	"encoding/csv"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-// Read the token API list from the CSV file
-func getCoinGeckoTokenIds(filePathtokenApiListPath string) (map[string]string, error) {
+// coinListEntry is a single coin of the CoinGecko token API list
+type coinListEntry struct {
+	ID     string `json:"id"`
+	Symbol string `json:"symbol"`
+	Name   string `json:"name"`
+	// Platforms maps the chains the coin lives on to its contract address, only in downloaded lists
+	Platforms map[string]string `json:"platforms,omitempty"`
+	// MarketCapRank is the optional market cap rank of the coin, zero when unknown
+	MarketCapRank int `json:"market_cap_rank,omitempty"`
+}
+
+// Read the token API list, grouping the coins by lowercase symbol. The list is either the bundled CSV file
+// or a JSON version downloaded from /coins/list. Many coins share a symbol, so a symbol may have several candidates.
+func getCoinGeckoTokenIds(filePathtokenApiListPath string) (map[string][]coinListEntry, error) {
+	if strings.EqualFold(filepath.Ext(filePathtokenApiListPath), ".json") {
+		entries, err := readTokenListJSON(filePathtokenApiListPath)
+		if err != nil {
+			return nil, err
+		}
+		symbolToIds := make(map[string][]coinListEntry)
+		for _, entry := range entries {
+			entry.Symbol = strings.ToLower(entry.Symbol)
+			symbolToIds[entry.Symbol] = append(symbolToIds[entry.Symbol], entry)
+		}
+		return symbolToIds, nil
+	}
+
 	f, err := os.Open(filePathtokenApiListPath)
 	if err != nil {
 		return nil, err
@@ -14,6 +42,8 @@ func getCoinGeckoTokenIds(filePathtokenApiListPath string) (map[string]string, e
 	defer f.Close()
 
 	csvReader := csv.NewReader(f)
+	// the market cap rank column is optional
+	csvReader.FieldsPerRecord = -1
 	// skip the header
 	_, err = csvReader.Read()
 	if err != nil {
@@ -24,11 +54,18 @@ func getCoinGeckoTokenIds(filePathtokenApiListPath string) (map[string]string, e
 		return nil, err
 	}
 
-	symbolToIdMap := make(map[string]string)
+	symbolToIds := make(map[string][]coinListEntry)
 	for _, record := range records {
-		// record[0] is the token ID, record[1] is the currency symbol
-		symbolToIdMap[record[1]] = record[0]
+		if len(record) < 3 {
+			continue
+		}
+		// record[0] is the token ID, record[1] is the currency symbol, record[2] the name
+		entry := coinListEntry{ID: record[0], Symbol: strings.ToLower(record[1]), Name: record[2]}
+		if len(record) > 3 {
+			entry.MarketCapRank, _ = strconv.Atoi(record[3])
+		}
+		symbolToIds[entry.Symbol] = append(symbolToIds[entry.Symbol], entry)
 	}
 
-	return symbolToIdMap, nil
+	return symbolToIds, nil
 }
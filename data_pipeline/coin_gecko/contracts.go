@@ -0,0 +1,110 @@
+package coingecko
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+)
+
+// contractKey identifies a token by its contract address on an asset platform
+type contractKey struct {
+	platform string
+	address  string
+}
+
+// contractOf returns the contract the price key identifies its currency by, ok is false if it has none
+func (geckoClient *CoinGeckoClient) contractOf(key models.PriceKey) (contractKey, bool) {
+	if !key.HasContract() {
+		return contractKey{}, false
+	}
+	// contract addresses are matched case-insensitively, as EVM addresses come in mixed case checksum form
+	return contractKey{platform: geckoClient.platform(key.Chain), address: strings.ToLower(key.Contract)}, true
+}
+
+// platform maps a chain name of the export onto the CoinGecko asset platform ID
+func (geckoClient *CoinGeckoClient) platform(chain string) string {
+	if platform := lookupFold(geckoClient.chainPlatforms, chain); platform != "" {
+		return platform
+	}
+	return strings.ToLower(chain)
+}
+
+// contractIndex maps the contracts listed in a downloaded token list onto their coin IDs
+func contractIndex(symbolToIds map[string][]coinListEntry) map[contractKey]string {
+	index := make(map[contractKey]string)
+	for _, entries := range symbolToIds {
+		for _, entry := range entries {
+			for platform, address := range entry.Platforms {
+				if platform != "" && address != "" {
+					index[contractKey{platform: platform, address: strings.ToLower(address)}] = entry.ID
+				}
+			}
+		}
+	}
+	return index
+}
+
+// resolveContracts resolves the contracts of the keys to coin IDs, from the token list when it lists the contract
+// and from the contract endpoint of the API otherwise. Contracts unknown to CoinGecko are left out,
+// so their keys fall back to the symbol. Every contract is asked from the API once per client.
+func (geckoClient *CoinGeckoClient) resolveContracts(ctx context.Context, symbolToIds map[string][]coinListEntry, keys []models.PriceKey) (map[contractKey]string, error) {
+	index := contractIndex(symbolToIds)
+	resolved := make(map[contractKey]string)
+	tried := make(map[contractKey]bool)
+	for _, key := range keys {
+		contract, ok := geckoClient.contractOf(key)
+		if !ok || key.CoinID != "" || tried[contract] {
+			continue
+		}
+		tried[contract] = true
+
+		if id, ok := index[contract]; ok {
+			resolved[contract] = id
+			continue
+		}
+		id, ok := geckoClient.contractIDs[contract]
+		if !ok {
+			// the address is sent as it is, addresses of non-EVM chains are case-sensitive
+			var err error
+			id, err = geckoClient.getContractCoinID(ctx, contract.platform, key.Contract)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve contract %s on %s: %v", key.Contract, key.Chain, err)
+			}
+			if geckoClient.contractIDs == nil {
+				geckoClient.contractIDs = make(map[contractKey]string)
+			}
+			geckoClient.contractIDs[contract] = id
+		}
+		if id != "" {
+			resolved[contract] = id
+		}
+	}
+	return resolved, nil
+}
+
+// getContractCoinID returns the ID of the coin with the given contract, empty if CoinGecko does not know it
+func (geckoClient *CoinGeckoClient) getContractCoinID(ctx context.Context, platform, address string) (string, error) {
+	url := fmt.Sprintf("%s/coins/%s/contract/%s", geckoClient.baseUrl, platform, address)
+	resp, err := geckoClient.get(ctx, url)
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.statusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var coin struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&coin); err != nil {
+		return "", err
+	}
+	return coin.ID, nil
+}
@@ -0,0 +1,137 @@
+package coingecko
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockContractTokenIds returns a downloaded token list with the platforms of the coins
+func mockContractTokenIds(tokenApiListPath string) (map[string][]coinListEntry, error) {
+	return map[string][]coinListEntry{
+		"usdc": {
+			{ID: "usd-coin", Symbol: "usdc", Name: "USDC", Platforms: map[string]string{"ethereum": "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"}},
+			{ID: "bridged-usdc-polygon-pos-bridge", Symbol: "usdc", Name: "Bridged USDC (Polygon PoS Bridge)", Platforms: map[string]string{"polygon-pos": "0x2791bca1f2de4661ed88a30c99a7a9449aa84174"}},
+		},
+		"eth": {{ID: "ethereum", Symbol: "eth", Name: "Ethereum"}},
+	}, nil
+}
+
+func TestCoinGeckoClient_GetPrices_ByContract(t *testing.T) {
+	var requestedUrls []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedUrls = append(requestedUrls, r.URL.Path)
+		switch r.URL.Path {
+		case "/coins/solana/contract/EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v":
+			w.Write([]byte(`{"id": "usd-coin", "symbol": "usdc"}`))
+		case "/coins/ethereum/contract/0x0000000000000000000000000000000000000001":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "coin not found"}`))
+		default:
+			w.Write([]byte(`{"market_data": {"current_price": {"usd": 1}}}`))
+		}
+	}))
+	defer mockServer.Close()
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:          mockServer.URL,
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockContractTokenIds,
+		chainPlatforms:   map[string]string{"Polygon": "polygon-pos"},
+	}
+
+	bridged := models.PriceKey{Symbol: "USDC", Date: "2023-01-01", Chain: "polygon", Contract: "0x2791Bca1f2de4661ED88A30C99A7a9449Aa84174"}
+	solana := models.PriceKey{Symbol: "USDC", Date: "2023-01-01", Chain: "solana", Contract: "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v"}
+	unknown := models.PriceKey{Symbol: "USDC", Date: "2023-01-01", Chain: "ethereum", Contract: "0x0000000000000000000000000000000000000001"}
+	priceMap, err := geckoClient.GetPrices(context.TODO(), []models.PriceKey{bridged, solana, unknown})
+	assert.NoError(t, err)
+	assert.Len(t, priceMap, 3)
+
+	assert.Equal(t, []string{
+		// the polygon contract is listed in the token list, the others are asked from the contract endpoint
+		"/coins/solana/contract/EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v",
+		"/coins/ethereum/contract/0x0000000000000000000000000000000000000001",
+		"/coins/bridged-usdc-polygon-pos-bridge/history",
+		// the unknown contract falls back to the symbol, which shares the history of the solana contract
+		"/coins/usd-coin/history",
+	}, requestedUrls)
+	assert.Equal(t, []AmbiguousSymbol{{
+		Symbol:     "USDC",
+		Chosen:     "usd-coin",
+		Candidates: []string{"usd-coin", "bridged-usdc-polygon-pos-bridge"},
+	}}, geckoClient.AmbiguousSymbols())
+}
+
+func TestCoinGeckoClient_GetPrices_ContractError(t *testing.T) {
+	mockServer := setupMockServer("{}", http.StatusUnauthorized)
+	defer mockServer.Close()
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:          mockServer.URL,
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockContractTokenIds,
+	}
+
+	_, err := geckoClient.GetPrices(context.TODO(), []models.PriceKey{
+		{Symbol: "USDC", Date: "2023-01-01", Chain: "base", Contract: "0x833589fcd6edb6e08f4c7c32d4f71b54bda02913"},
+	})
+	assert.ErrorContains(t, err, "failed to resolve contract 0x833589fcd6edb6e08f4c7c32d4f71b54bda02913 on base")
+}
+
+func TestCoinGeckoClient_UnknownSymbols_Contract(t *testing.T) {
+	var requestedUrls []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/history") {
+			w.Write([]byte(`{"market_data": {"current_price": {"usd": 1}}}`))
+			return
+		}
+		requestedUrls = append(requestedUrls, r.URL.Path)
+		if r.URL.Path == "/coins/ethereum/contract/0x0000000000000000000000000000000000000001" {
+			w.Write([]byte(`{"id": "foo-coin", "symbol": "foo"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "coin not found"}`))
+	}))
+	defer mockServer.Close()
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:          mockServer.URL,
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockContractTokenIds,
+	}
+
+	keys := []models.PriceKey{
+		// a known contract makes up for an unknown symbol, listed in the token list or not
+		{Symbol: "USDC.E", Date: "2023-01-01", Chain: "ethereum", Contract: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"},
+		{Symbol: "FOO", Date: "2023-01-01", Chain: "ethereum", Contract: "0x0000000000000000000000000000000000000001"},
+		// an unknown contract is priced by its symbol, which is unknown as well
+		{Symbol: "BAZ", Date: "2023-01-01", Chain: "ethereum", Contract: "0x0000000000000000000000000000000000000003"},
+		{Symbol: "BAZ", Date: "2023-01-02", Chain: "ethereum", Contract: "0x0000000000000000000000000000000000000003"},
+		// or by a known symbol
+		{Symbol: "ETH", Date: "2023-01-01", Chain: "ethereum", Contract: "0x0000000000000000000000000000000000000004"},
+		// a contract without a chain cannot be resolved
+		{Symbol: "BAR", Date: "2023-01-01", Contract: "0x0000000000000000000000000000000000000002"},
+	}
+	unknown, contracts, err := geckoClient.UnknownSymbols(context.TODO(), keys)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"BAR", "BAZ"}, unknown)
+	assert.Equal(t, []models.PriceKey{
+		{Symbol: "BAZ", Chain: "ethereum", Contract: "0x0000000000000000000000000000000000000003"},
+		{Symbol: "ETH", Chain: "ethereum", Contract: "0x0000000000000000000000000000000000000004"},
+	}, contracts)
+
+	// the contracts are asked from the API once
+	_, err = geckoClient.GetPrices(context.TODO(), keys[:2])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"/coins/ethereum/contract/0x0000000000000000000000000000000000000001",
+		"/coins/ethereum/contract/0x0000000000000000000000000000000000000003",
+		"/coins/ethereum/contract/0x0000000000000000000000000000000000000004",
+	}, requestedUrls)
+}
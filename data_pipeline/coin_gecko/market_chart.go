@@ -0,0 +1,168 @@
+package coingecko
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+)
+
+// maxSampleDistance is how far the nearest sample may be from the priced instant, one missing hourly sample is tolerated
+const maxSampleDistance = time.Hour
+
+// marketChartResponse is the response of /coins/{id}/market_chart/range, prices are [unix millis, price] pairs
+type marketChartResponse struct {
+	Prices [][2]float64 `json:"prices"`
+}
+
+// sample is the price of a coin at an instant
+type sample struct {
+	at    time.Time
+	price float64
+}
+
+// chartWindow is the longest range a single market_chart/range request may span and still be sampled hourly.
+// Past ranges are never sampled more often, 5-minute samples are only served for the last day.
+const chartWindow = 90 * 24 * time.Hour
+
+// chartKeys holds the keys priced by the chart of a coin in a fiat currency
+type chartKeys struct {
+	coinID string
+	fiat   string
+	keys   []models.PriceKey
+}
+
+// getIntradayPrices prices every key at the sample nearest to its time, fetching the chart of each coin
+// once per fiat currency over the time span of its keys, and fills in the provenance of every price found
+func (geckoClient *CoinGeckoClient) getIntradayPrices(ctx context.Context, keys []models.PriceKey, coinIDs map[models.PriceKey]string, provenance map[models.PriceKey]models.PriceProvenance) (models.PriceMap, error) {
+	prices := make(models.PriceMap, len(keys))
+
+	// group the keys which are not cached by coin and fiat currency, in the order of their first key
+	var charts []*chartKeys
+	byChart := make(map[string]*chartKeys)
+	for _, key := range keys {
+		coinID, fiat := coinIDs[key], fiatOf(key)
+		cached, ok, err := geckoClient.getCachedIntradayPrice(coinID, fiat, key.Time)
+		if err != nil {
+			return prices, err
+		}
+		if ok {
+			prices[key] = cached.Price
+			provenance[key] = models.PriceProvenance{CoinID: coinID, FetchedAt: cached.FetchedAt, CacheHit: true}
+			continue
+		}
+		chart, ok := byChart[coinID+"/"+fiat]
+		if !ok {
+			chart = &chartKeys{coinID: coinID, fiat: fiat}
+			byChart[coinID+"/"+fiat] = chart
+			charts = append(charts, chart)
+		}
+		chart.keys = append(chart.keys, key)
+	}
+
+	for _, chart := range charts {
+		from, to := chart.keys[0].Time, chart.keys[0].Time
+		for _, key := range chart.keys {
+			from = min(from, key.Time)
+			to = max(to, key.Time)
+		}
+		samples, err := geckoClient.getMarketChart(ctx, chart.coinID, chart.fiat,
+			time.Unix(from, 0).Add(-maxSampleDistance), time.Unix(to, 0).Add(maxSampleDistance))
+		if err != nil {
+			return prices, fmt.Errorf("failed to get price chart of %s: %v", chart.coinID, err)
+		}
+		fetchedAt := time.Now().UTC()
+
+		for _, key := range chart.keys {
+			price, ok := nearestSample(samples, time.Unix(key.Time, 0))
+			if !ok {
+				continue
+			}
+			if err := geckoClient.putCachedIntradayPrice(chart.coinID, chart.fiat, key.Time, price); err != nil {
+				return prices, err
+			}
+			prices[key] = price
+			provenance[key] = models.PriceProvenance{CoinID: chart.coinID, FetchedAt: fetchedAt}
+		}
+	}
+	return prices, nil
+}
+
+// getMarketChart returns the price samples of the coin in the fiat currency between from and to sorted by time,
+// split into requests of chartWindow
+func (geckoClient *CoinGeckoClient) getMarketChart(ctx context.Context, coinID, fiat string, from, to time.Time) ([]sample, error) {
+	var samples []sample
+	for start := from; start.Before(to); start = start.Add(chartWindow) {
+		end := start.Add(chartWindow)
+		if end.After(to) {
+			end = to
+		}
+		url := fmt.Sprintf("%s/coins/%s/market_chart/range?vs_currency=%s&from=%d&to=%d", geckoClient.baseUrl, coinID, fiat, start.Unix(), end.Unix())
+		resp, err := geckoClient.get(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		var chart marketChartResponse
+		err = json.NewDecoder(resp.Body).Decode(&chart)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, point := range chart.Prices {
+			samples = append(samples, sample{at: time.UnixMilli(int64(point[0])), price: point[1]})
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].at.Before(samples[j].at) })
+	return samples, nil
+}
+
+// nearestSample returns the price of the sample nearest to the instant, ok is false if none is close enough
+func nearestSample(samples []sample, at time.Time) (float64, bool) {
+	// the first sample at or after the instant, the nearest one is either it or the one before it
+	i := sort.Search(len(samples), func(i int) bool { return !samples[i].at.Before(at) })
+	best, bestDistance := 0.0, time.Duration(-1)
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(samples) {
+			continue
+		}
+		distance := samples[j].at.Sub(at).Abs()
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = samples[j].price, distance
+		}
+	}
+	if bestDistance < 0 || bestDistance > maxSampleDistance || best == 0 {
+		return 0, false
+	}
+	return best, true
+}
+
+// intradayCacheKey keys intraday prices by their instant instead of their day
+func intradayCacheKey(coinID, fiat string, unix int64) CacheKey {
+	return CacheKey{Provider: "coingecko", CoinID: coinID, Date: time.Unix(unix, 0).UTC().Format(time.RFC3339), Fiat: fiat}
+}
+
+// getCachedIntradayPrice returns the cached price of the coin at the instant, ok is false on a miss or without a cache
+func (geckoClient *CoinGeckoClient) getCachedIntradayPrice(coinID, fiat string, unix int64) (CachedPrice, bool, error) {
+	if geckoClient.cache == nil {
+		return CachedPrice{}, false, nil
+	}
+	cached, ok, err := geckoClient.cache.Lookup(intradayCacheKey(coinID, fiat, unix))
+	if err != nil {
+		return CachedPrice{}, false, fmt.Errorf("failed to read price cache: %v", err)
+	}
+	return cached, ok, nil
+}
+
+// putCachedIntradayPrice caches the price of the coin at the instant, if there is a cache
+func (geckoClient *CoinGeckoClient) putCachedIntradayPrice(coinID, fiat string, unix int64, price float64) error {
+	if geckoClient.cache == nil {
+		return nil
+	}
+	if err := geckoClient.cache.Put(intradayCacheKey(coinID, fiat, unix), price); err != nil {
+		return fmt.Errorf("failed to write price cache: %v", err)
+	}
+	return nil
+}
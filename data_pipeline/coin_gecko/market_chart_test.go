@@ -0,0 +1,99 @@
+package coingecko
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoinGeckoClient_GetPrices_Intraday(t *testing.T) {
+	var requestedUrls []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedUrls = append(requestedUrls, r.URL.String())
+		// samples at 09:58, 10:03 and 11:02 on 2024-04-01
+		w.Write([]byte(`{"prices": [[1711965480000, 3490], [1711965780000, 3500], [1711969320000, 3600]]}`))
+	}))
+	defer mockServer.Close()
+
+	cache, err := OpenPriceCache(filepath.Join(t.TempDir(), "prices.db"))
+	assert.NoError(t, err)
+	defer cache.Close()
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:          mockServer.URL,
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockGetCoinGeckoTokenIds,
+		cache:            cache,
+	}
+
+	ten := models.PriceKey{Symbol: "ETH", Date: "2024-04-01", Time: time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC).Unix()}
+	eleven := models.PriceKey{Symbol: "ETH", Date: "2024-04-01", Time: time.Date(2024, 4, 1, 11, 0, 0, 0, time.UTC).Unix()}
+	// no sample within an hour
+	evening := models.PriceKey{Symbol: "ETH", Date: "2024-04-01", Time: time.Date(2024, 4, 1, 18, 0, 0, 0, time.UTC).Unix()}
+	priceMap, err := geckoClient.GetPrices(context.TODO(), []models.PriceKey{ten, eleven, evening})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{ten: 3490, eleven: 3600}, priceMap)
+
+	// the chart of the coin is fetched once over the span of its keys
+	assert.Equal(t, []string{
+		"/coins/ethereum/market_chart/range?vs_currency=usd&from=1711962000&to=1711998000",
+	}, requestedUrls)
+
+	// the samples are cached by their instant
+	priceMap, err = geckoClient.GetPrices(context.TODO(), []models.PriceKey{ten, eleven})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{ten: 3490, eleven: 3600}, priceMap)
+	assert.Len(t, requestedUrls, 1)
+}
+
+func TestCoinGeckoClient_GetMarketChart_Windows(t *testing.T) {
+	var requestedUrls []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedUrls = append(requestedUrls, r.URL.RawQuery)
+		w.Write([]byte(`{"prices": []}`))
+	}))
+	defer mockServer.Close()
+
+	geckoClient := &CoinGeckoClient{baseUrl: mockServer.URL}
+	from := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	// hourly samples are only served for ranges of up to 90 days
+	_, err := geckoClient.getMarketChart(context.TODO(), "ethereum", "usd", from, from.AddDate(0, 0, 100))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"vs_currency=usd&from=1711929600&to=1719705600",
+		"vs_currency=usd&from=1719705600&to=1720569600",
+	}, requestedUrls)
+}
+
+func TestNearestSample(t *testing.T) {
+	at := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+	samples := []sample{
+		{at: at.Add(-20 * time.Minute), price: 1},
+		{at: at.Add(10 * time.Minute), price: 2},
+		{at: at.Add(3 * time.Hour), price: 3},
+	}
+
+	price, ok := nearestSample(samples, at)
+	assert.True(t, ok)
+	assert.Equal(t, 2.0, price)
+
+	// before the first and after the last sample
+	price, ok = nearestSample(samples, at.Add(-time.Hour))
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, price)
+	price, ok = nearestSample(samples, at.Add(3*time.Hour+time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, 3.0, price)
+
+	_, ok = nearestSample(samples, at.Add(90*time.Minute))
+	assert.False(t, ok)
+	_, ok = nearestSample(nil, at)
+	assert.False(t, ok)
+}
@@ -0,0 +1,76 @@
+package coingecko
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+)
+
+// Peg prices a currency without asking CoinGecko for the price of its own coin
+type Peg struct {
+	// Price is the fixed USD price of a stablecoin, e.g. 1
+	Price float64
+	// CoinID is the coin a wrapped asset is priced by, e.g. "ethereum" for WETH
+	CoinID string
+}
+
+// Depeg is a sampled price of a stablecoin which deviates from its peg by more than the threshold
+type Depeg struct {
+	Symbol string
+	Date   string
+	Peg    float64
+	Price  float64
+}
+
+// Deviation returns the relative deviation of the price from the peg
+func (depeg Depeg) Deviation() float64 {
+	return math.Abs(depeg.Price-depeg.Peg) / depeg.Peg
+}
+
+func (depeg Depeg) String() string {
+	return fmt.Sprintf("%s on %s: %g instead of %g (%.2f%% off)", depeg.Symbol, depeg.Date, depeg.Price, depeg.Peg, 100*depeg.Deviation())
+}
+
+// normalizePegs validates the pegs and keys them by uppercase symbol
+func normalizePegs(pegs map[string]Peg) (map[string]Peg, error) {
+	normalized := make(map[string]Peg, len(pegs))
+	for symbol, peg := range pegs {
+		if (peg.Price > 0) == (peg.CoinID != "") {
+			return nil, fmt.Errorf("peg of %s needs either a positive price or a coin ID", symbol)
+		}
+		normalized[strings.ToUpper(symbol)] = peg
+	}
+	return normalized, nil
+}
+
+// pegOf returns the peg of the currency of the key. Pegs are matched by symbol, so they apply to keys pinned to a coin
+// as well, the pinned coin is only asked for the prices in other fiat currencies and the depeg samples.
+func (geckoClient *CoinGeckoClient) pegOf(key models.PriceKey) (Peg, bool) {
+	peg, ok := geckoClient.pegs[strings.ToUpper(key.Symbol)]
+	return peg, ok
+}
+
+// underlyingKey returns the key of the price of the coin a wrapped asset is pegged to
+func underlyingKey(key models.PriceKey, peg Peg) models.PriceKey {
+	return models.PriceKey{Symbol: key.Symbol, Date: key.Date, Time: key.Time, CoinID: peg.CoinID, Fiat: key.Fiat}
+}
+
+// depegSamples picks up to n keys of every stablecoin, evenly spread from its last key backwards,
+// whose real price is fetched to detect a depeg
+func depegSamples(pegged map[string][]models.PriceKey, symbols []string, n int) []models.PriceKey {
+	var samples []models.PriceKey
+	for _, symbol := range symbols {
+		keys := pegged[symbol]
+		if n >= len(keys) {
+			samples = append(samples, keys...)
+			continue
+		}
+		for i := 0; i < n; i++ {
+			// n == 1 samples the last key only
+			samples = append(samples, keys[len(keys)-1-i*(len(keys)-1)/max(n-1, 1)])
+		}
+	}
+	return samples
+}
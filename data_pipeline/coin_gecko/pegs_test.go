@@ -0,0 +1,143 @@
+package coingecko
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockPeggedTokenIds returns a token list with stablecoins and wrapped assets
+func mockPeggedTokenIds(tokenApiListPath string) (map[string][]coinListEntry, error) {
+	return map[string][]coinListEntry{
+		"eth":  {{ID: "ethereum", Symbol: "eth", Name: "Ethereum"}},
+		"weth": {{ID: "weth", Symbol: "weth", Name: "WETH"}},
+		"usdc": {{ID: "usd-coin", Symbol: "usdc", Name: "USDC"}},
+	}, nil
+}
+
+func TestCoinGeckoClient_GetPrices_Pegs(t *testing.T) {
+	var requestedUrls []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedUrls = append(requestedUrls, r.URL.Path)
+		w.Write([]byte(`{"market_data": {"current_price": {"usd": 3000, "eur": 2800}}}`))
+	}))
+	defer mockServer.Close()
+
+	pegs, err := normalizePegs(map[string]Peg{"usdc": {Price: 1}, "WETH": {CoinID: "ethereum"}})
+	assert.NoError(t, err)
+	geckoClient := &CoinGeckoClient{
+		baseUrl:          mockServer.URL,
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockPeggedTokenIds,
+		pegs:             pegs,
+	}
+
+	eth := models.PriceKey{Symbol: "ETH", Date: "2023-01-01"}
+	weth := models.PriceKey{Symbol: "WETH", Date: "2023-01-01"}
+	usdc := models.PriceKey{Symbol: "USDC", Date: "2023-01-01"}
+	usdcInEur := models.PriceKey{Symbol: "USDC", Date: "2023-01-01", Fiat: "eur"}
+	// a coin pinned via coinIds is still pegged, only its price in other fiat currencies is fetched by the coin
+	pinned := models.PriceKey{Symbol: "USDC", Date: "2023-01-01", CoinID: "bridged-usdc"}
+	pinnedInEur := models.PriceKey{Symbol: "USDC", Date: "2023-01-01", CoinID: "bridged-usdc", Fiat: "eur"}
+	priceMap, err := geckoClient.GetPrices(context.TODO(), []models.PriceKey{eth, weth, usdc, usdcInEur, pinned, pinnedInEur})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{eth: 3000, weth: 3000, usdc: 1, usdcInEur: 2800, pinned: 1, pinnedInEur: 2800}, priceMap)
+
+	// the wrapped asset shares the request of its underlying coin, the USD peg is not fetched
+	assert.Equal(t, []string{
+		"/coins/ethereum/history",
+		"/coins/usd-coin/history",
+		"/coins/bridged-usdc/history",
+	}, requestedUrls)
+	assert.Equal(t, models.PriceProvenance{Provider: "coingecko/peg"}, geckoClient.Provenance()[pinned])
+	assert.Empty(t, geckoClient.Depegs())
+
+	// the wrapped asset is attributed to its underlying coin, the stablecoin to its peg
+	assert.Equal(t, "ethereum", geckoClient.Provenance()[weth].CoinID)
+	assert.Equal(t, models.PriceProvenance{Provider: "coingecko/peg"}, geckoClient.Provenance()[usdc])
+	assert.Equal(t, "usd-coin", geckoClient.Provenance()[usdcInEur].CoinID)
+
+	unknown, _, err := geckoClient.UnknownSymbols(context.TODO(), []models.PriceKey{{Symbol: "WETH.E", Date: "2023-01-01"}, {Symbol: "weth", Date: "2023-01-01"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"WETH.E"}, unknown)
+}
+
+func TestCoinGeckoClient_GetPrices_Depeg(t *testing.T) {
+	var requestedDates []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "false", r.URL.Query().Get("localization"))
+		requestedDates = append(requestedDates, r.URL.Query().Get("date"))
+		if r.URL.Query().Get("date") == "03-01-2023" {
+			w.Write([]byte(`{"market_data": {"current_price": {"usd": 0.9}}}`))
+			return
+		}
+		w.Write([]byte(`{"market_data": {"current_price": {"usd": 0.999}}}`))
+	}))
+	defer mockServer.Close()
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:          mockServer.URL,
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockPeggedTokenIds,
+		pegs:             map[string]Peg{"USDC": {Price: 1}},
+		depegThreshold:   0.02,
+		depegSamples:     2,
+	}
+
+	var keys []models.PriceKey
+	for _, date := range []string{"2023-01-01", "2023-01-02", "2023-01-03"} {
+		keys = append(keys, models.PriceKey{Symbol: "USDC", Date: date})
+	}
+	priceMap, err := geckoClient.GetPrices(context.TODO(), keys)
+	assert.NoError(t, err)
+	// the peg is still used, the sample only warns
+	assert.Equal(t, models.PriceMap{keys[0]: 1, keys[1]: 1, keys[2]: 1}, priceMap)
+
+	// the first and the last day are sampled
+	assert.Equal(t, []string{
+		"03-01-2023",
+		"01-01-2023",
+	}, requestedDates)
+	assert.Equal(t, []Depeg{{Symbol: "USDC", Date: "2023-01-03", Peg: 1, Price: 0.9}}, geckoClient.Depegs())
+	assert.Equal(t, "USDC on 2023-01-03: 0.9 instead of 1 (10.00% off)", geckoClient.Depegs()[0].String())
+}
+
+func TestCoinGeckoClient_GetPrices_FailedDepegSample(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/coins/usd-coin/history" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"market_data": {"current_price": {"usd": 3000}}}`))
+	}))
+	defer mockServer.Close()
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:          mockServer.URL,
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockPeggedTokenIds,
+		pegs:             map[string]Peg{"USDC": {Price: 1}},
+		depegThreshold:   0.02,
+		depegSamples:     1,
+	}
+
+	usdc := models.PriceKey{Symbol: "USDC", Date: "2023-01-01"}
+	eth := models.PriceKey{Symbol: "ETH", Date: "2023-01-01"}
+	priceMap, err := geckoClient.GetPrices(context.TODO(), []models.PriceKey{usdc, eth})
+	// the failed sample neither fails nor cancels the prices
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{usdc: 1, eth: 3000}, priceMap)
+	assert.Empty(t, geckoClient.Depegs())
+}
+
+func TestNormalizePegs(t *testing.T) {
+	_, err := normalizePegs(map[string]Peg{"usdc": {}})
+	assert.ErrorContains(t, err, "peg of usdc needs either a positive price or a coin ID")
+
+	_, err = normalizePegs(map[string]Peg{"weth": {Price: 1, CoinID: "ethereum"}})
+	assert.Error(t, err)
+}
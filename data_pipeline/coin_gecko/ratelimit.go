@@ -0,0 +1,200 @@
+package coingecko
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Plan is a CoinGecko API plan tier, it decides the endpoint, the API key header and how many requests are sent per minute
+type Plan string
+
+const (
+	PlanPublic  Plan = "public"
+	PlanDemo    Plan = "demo"
+	PlanAnalyst Plan = "analyst"
+	PlanLite    Plan = "lite"
+	PlanPro     Plan = "pro"
+)
+
+// planRateLimits holds the documented requests per minute of every plan
+var planRateLimits = map[Plan]int{
+	// the public API allows 5 to 15 calls per minute depending on the global load
+	PlanPublic:  5,
+	PlanDemo:    30,
+	PlanAnalyst: 500,
+	PlanLite:    500,
+	PlanPro:     1000,
+}
+
+// RateLimit returns the number of requests per minute allowed by the plan
+func (plan Plan) RateLimit() (int, error) {
+	if plan == "" {
+		plan = PlanPublic
+	}
+	limit, ok := planRateLimits[plan]
+	if !ok {
+		return 0, fmt.Errorf("unknown CoinGecko plan %q", plan)
+	}
+	return limit, nil
+}
+
+// The endpoints of the free and the paid plans
+const (
+	publicBaseUrl = "https://api.coingecko.com/api/v3"
+	proBaseUrl    = "https://pro-api.coingecko.com/api/v3"
+)
+
+// paid reports whether the plan is served by the pro API
+func (plan Plan) paid() bool {
+	return plan == PlanAnalyst || plan == PlanLite || plan == PlanPro
+}
+
+// BaseUrl returns the endpoint of the API serving the plan
+func (plan Plan) BaseUrl() string {
+	if plan.paid() {
+		return proBaseUrl
+	}
+	return publicBaseUrl
+}
+
+// apiKeyHeader returns the header carrying the API key of the plan, empty for the keyless public API
+func (plan Plan) apiKeyHeader() string {
+	switch {
+	case plan == PlanDemo:
+		return "x-cg-demo-api-key"
+	case plan.paid():
+		return "x-cg-pro-api-key"
+	}
+	return ""
+}
+
+// planFor returns the plan of the API key, a key without a plan is a demo key
+func planFor(plan Plan, apiKey string) (Plan, error) {
+	if plan == "" {
+		if apiKey == "" {
+			return PlanPublic, nil
+		}
+		return PlanDemo, nil
+	}
+	if _, err := plan.RateLimit(); err != nil {
+		return "", err
+	}
+	if plan == PlanPublic && apiKey != "" {
+		return "", fmt.Errorf("the public CoinGecko API takes no API key, set the plan of the key")
+	}
+	if plan != PlanPublic && apiKey == "" {
+		return "", fmt.Errorf("the %s CoinGecko plan requires an API key", plan)
+	}
+	return plan, nil
+}
+
+// tokenBucket limits the rate of requests, allowing short bursts of up to burst requests
+type tokenBucket struct {
+	mu sync.Mutex
+	// interval is the time it takes to refill a single token
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+	// injected functions for testing purposes
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// newTokenBucket creates a full token bucket handing out perMinute tokens a minute
+func newTokenBucket(perMinute, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		interval: time.Minute / time.Duration(perMinute),
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+		now:      time.Now,
+		sleep:    sleepContext,
+	}
+}
+
+// wait blocks until a token is available or the context is done
+func (bucket *tokenBucket) wait(ctx context.Context) error {
+	for {
+		bucket.mu.Lock()
+		now := bucket.now()
+		// refill the tokens accumulated since the last call
+		bucket.tokens += float64(now.Sub(bucket.last)) / float64(bucket.interval)
+		if bucket.tokens > bucket.burst {
+			bucket.tokens = bucket.burst
+		}
+		bucket.last = now
+		if bucket.tokens >= 1 {
+			bucket.tokens--
+			bucket.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - bucket.tokens) * float64(bucket.interval))
+		bucket.mu.Unlock()
+
+		if err := bucket.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// sleepContext sleeps for the given duration or until the context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// isRetryable reports whether a request that ended with the given status is worth retrying
+func isRetryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// retryAfter returns the delay requested by the Retry-After header of the response, if any.
+// The header holds either a number of seconds or an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// backoff returns the delay before the given retry, doubling from min up to max with equal jitter
+func backoff(attempt int, min, max time.Duration) time.Duration {
+	delay := min
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// keep at least half of the delay so retries never come in a burst
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
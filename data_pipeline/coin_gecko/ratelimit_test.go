@@ -0,0 +1,312 @@
+package coingecko
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordSleeps returns a sleep function recording the requested delays instead of sleeping
+func TestCoinGeckoClient_Get_RetriesStalledRequest(t *testing.T) {
+	var requests int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first request stalls until the client gives up on it
+		if atomic.AddInt32(&requests, 1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer mockServer.Close()
+
+	defaultClient := httpClient
+	httpClient = &http.Client{Timeout: 50 * time.Millisecond}
+	defer func() { httpClient = defaultClient }()
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:    mockServer.URL,
+		maxRetries: 1,
+		sleep:      recordSleeps(new([]time.Duration)),
+	}
+	resp, err := geckoClient.get(context.TODO(), mockServer.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func recordSleeps(delays *[]time.Duration) func(ctx context.Context, d time.Duration) error {
+	var mu sync.Mutex
+	return func(ctx context.Context, d time.Duration) error {
+		mu.Lock()
+		defer mu.Unlock()
+		*delays = append(*delays, d)
+		return ctx.Err()
+	}
+}
+
+func TestPlan_RateLimit(t *testing.T) {
+	limit, err := Plan("").RateLimit()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, limit)
+
+	limit, err = PlanPro.RateLimit()
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, limit)
+
+	_, err = Plan("enterprise").RateLimit()
+	assert.ErrorContains(t, err, "unknown CoinGecko plan")
+
+	_, err = NewCoinGeckoClient("", "", ClientOptions{Plan: "enterprise"})
+	assert.Error(t, err)
+}
+
+func TestPlanFor(t *testing.T) {
+	plan, err := planFor("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, PlanPublic, plan)
+
+	// a key without a plan is a demo key
+	plan, err = planFor("", "key")
+	assert.NoError(t, err)
+	assert.Equal(t, PlanDemo, plan)
+
+	plan, err = planFor(PlanLite, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, PlanLite, plan)
+
+	_, err = planFor(PlanPro, "")
+	assert.ErrorContains(t, err, "the pro CoinGecko plan requires an API key")
+	_, err = planFor(PlanPublic, "key")
+	assert.ErrorContains(t, err, "takes no API key")
+
+	// every paid plan is served by the pro API
+	assert.Equal(t, publicBaseUrl, PlanDemo.BaseUrl())
+	assert.Equal(t, proBaseUrl, PlanAnalyst.BaseUrl())
+}
+
+func TestTokenBucket_Wait(t *testing.T) {
+	now := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	var delays []time.Duration
+
+	bucket := newTokenBucket(60, 2)
+	bucket.last = now
+	bucket.now = func() time.Time { return now }
+	bucket.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		now = now.Add(d)
+		return nil
+	}
+
+	// the burst is served right away, then one request per second
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, bucket.wait(context.TODO()))
+	}
+	assert.Equal(t, []time.Duration{time.Second, time.Second}, delays)
+
+	// idle time refills the bucket, but never beyond the burst
+	now = now.Add(time.Hour)
+	delays = nil
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, bucket.wait(context.TODO()))
+	}
+	assert.Equal(t, []time.Duration{time.Second}, delays)
+}
+
+func TestTokenBucket_Wait_Cancelled(t *testing.T) {
+	bucket := newTokenBucket(1, 1)
+	assert.NoError(t, bucket.wait(context.TODO()))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	assert.ErrorIs(t, bucket.wait(ctx), context.Canceled)
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		delay := backoff(attempt, time.Second, 5*time.Second)
+		assert.GreaterOrEqual(t, delay, max/2)
+		assert.LessOrEqual(t, delay, max)
+	}
+	assert.Equal(t, time.Duration(0), backoff(3, 0, 0))
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	response := func(header string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": []string{header}}}
+	}
+
+	delay, ok := retryAfter(response("3"), now)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, delay)
+
+	delay, ok = retryAfter(response(now.Add(time.Minute).Format(http.TimeFormat)), now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, delay)
+
+	_, ok = retryAfter(response("soon"), now)
+	assert.False(t, ok)
+	_, ok = retryAfter(&http.Response{Header: http.Header{}}, now)
+	assert.False(t, ok)
+}
+
+func TestCoinGeckoClient_Get_RetryAfter(t *testing.T) {
+	var requests int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// throttle the first two requests
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"market_data": {"current_price": {"usd": 42}}}`))
+	}))
+	defer mockServer.Close()
+
+	var delays []time.Duration
+	geckoClient := &CoinGeckoClient{
+		baseUrl:    mockServer.URL,
+		maxRetries: 3,
+		minBackoff: time.Second,
+		maxBackoff: time.Minute,
+		sleep:      recordSleeps(&delays),
+	}
+
+	prices, err := geckoClient.getFiatPrices(context.TODO(), "ethereum", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, 42.0, prices["usd"])
+	assert.Equal(t, int32(3), requests)
+	assert.Equal(t, []time.Duration{7 * time.Second, 7 * time.Second}, delays)
+}
+
+func TestCoinGeckoClient_Get_GivesUp(t *testing.T) {
+	var requests int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mockServer.Close()
+
+	var delays []time.Duration
+	geckoClient := &CoinGeckoClient{
+		baseUrl:    mockServer.URL,
+		maxRetries: 2,
+		minBackoff: time.Second,
+		maxBackoff: time.Minute,
+		sleep:      recordSleeps(&delays),
+	}
+
+	_, err := geckoClient.getFiatPrices(context.TODO(), "ethereum", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.ErrorContains(t, err, "giving up after 2 retries")
+	assert.Equal(t, int32(3), requests)
+	// exponential backoff with jitter, without a Retry-After header
+	if assert.Len(t, delays, 2) {
+		assert.InDelta(t, 0.75*float64(time.Second), float64(delays[0]), 0.25*float64(time.Second))
+		assert.InDelta(t, 1.5*float64(time.Second), float64(delays[1]), 0.5*float64(time.Second))
+	}
+}
+
+func TestCoinGeckoClient_Get_NotRetried(t *testing.T) {
+	var requests int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer mockServer.Close()
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:    mockServer.URL,
+		maxRetries: 5,
+		sleep:      recordSleeps(new([]time.Duration)),
+	}
+
+	_, err := geckoClient.getFiatPrices(context.TODO(), "unknown", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.ErrorContains(t, err, "404")
+	assert.Equal(t, int32(1), requests)
+}
+
+func TestCoinGeckoClient_GetPrices_Throttled(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		inFlight int
+		peak     int
+		served   = make(map[string]int)
+	)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		// every coin is throttled on its first request
+		served[r.URL.String()]++
+		throttled := served[r.URL.String()] == 1
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		if throttled {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"market_data": {"current_price": {"usd": 10}}}`))
+	}))
+	defer mockServer.Close()
+
+	geckoClient, err := NewCoinGeckoClient("", "mock/path", ClientOptions{RequestsPerMinute: 60000, Workers: 3, MaxRetries: 1})
+	assert.NoError(t, err)
+	geckoClient.baseUrl = mockServer.URL
+	geckoClient.getTokenIdsFunc = mockGetCoinGeckoTokenIds
+
+	var keys []models.PriceKey
+	expected := make(models.PriceMap)
+	for day := 1; day <= 10; day++ {
+		for _, symbol := range []string{"ETH", "BTC"} {
+			key := models.PriceKey{Symbol: symbol, Date: time.Date(2023, 1, day, 0, 0, 0, 0, time.UTC).Format(time.DateOnly)}
+			keys = append(keys, key)
+			expected[key] = 10
+		}
+	}
+
+	priceMap, err := geckoClient.GetPrices(context.TODO(), keys)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, priceMap)
+	assert.LessOrEqual(t, peak, 3)
+	assert.Len(t, served, 20)
+}
+
+func TestCoinGeckoClient_GetPrices_FirstErrorStops(t *testing.T) {
+	var requests int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer mockServer.Close()
+
+	geckoClient := &CoinGeckoClient{
+		baseUrl:          mockServer.URL,
+		tokenApiListPath: "mock/path",
+		getTokenIdsFunc:  mockGetCoinGeckoTokenIds,
+		workers:          2,
+	}
+
+	var keys []models.PriceKey
+	for day := 1; day <= 28; day++ {
+		keys = append(keys, models.PriceKey{Symbol: "ETH", Date: time.Date(2023, 2, day, 0, 0, 0, 0, time.UTC).Format(time.DateOnly)})
+	}
+
+	_, err := geckoClient.GetPrices(context.TODO(), keys)
+	assert.ErrorContains(t, err, "403")
+	assert.Less(t, int(atomic.LoadInt32(&requests)), len(keys))
+}
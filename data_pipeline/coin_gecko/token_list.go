@@ -0,0 +1,160 @@
+package coingecko
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tokenListPrefix and tokenListLayout name the versions of the token list, sorting them by download time
+const (
+	tokenListPrefix = "coins-list-"
+	tokenListLayout = "20060102T150405Z"
+)
+
+// TokenListDiff describes how a downloaded token list differs from the previous version
+type TokenListDiff struct {
+	// Previous is the path of the version compared against, empty for the first download
+	Previous string
+	// IDs of the coins which were added, removed or changed their symbol, name or platforms
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// Empty reports whether the lists are the same
+func (diff TokenListDiff) Empty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0
+}
+
+// RefreshTokenList downloads the token list with the platform contract addresses of every coin from /coins/list
+// and stores it as a new version in the directory, unless it equals the latest version.
+// It returns the path of the latest version and the differences to the previous one.
+func (geckoClient *CoinGeckoClient) RefreshTokenList(ctx context.Context, dir string) (string, TokenListDiff, error) {
+	url := fmt.Sprintf("%s/coins/list?include_platform=true", geckoClient.baseUrl)
+	resp, err := geckoClient.get(ctx, url)
+	if err != nil {
+		return "", TokenListDiff{}, fmt.Errorf("failed to download token list: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var entries []coinListEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return "", TokenListDiff{}, fmt.Errorf("failed to decode token list: %v", err)
+	}
+	if len(entries) == 0 {
+		return "", TokenListDiff{}, fmt.Errorf("downloaded token list is empty")
+	}
+
+	return saveTokenList(dir, entries, time.Now())
+}
+
+// saveTokenList stores the entries as a new version in the directory, unless they equal the latest version
+func saveTokenList(dir string, entries []coinListEntry, now time.Time) (string, TokenListDiff, error) {
+	previous, err := LatestTokenList(dir)
+	if err != nil {
+		return "", TokenListDiff{}, err
+	}
+
+	diff := TokenListDiff{Previous: previous}
+	var previousEntries []coinListEntry
+	if previous != "" {
+		previousEntries, err = readTokenListJSON(previous)
+		if err != nil {
+			return "", TokenListDiff{}, err
+		}
+	}
+	diff.Added, diff.Removed, diff.Changed = diffTokenLists(previousEntries, entries)
+	if previous != "" && diff.Empty() {
+		return previous, diff, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", TokenListDiff{}, err
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return "", TokenListDiff{}, err
+	}
+	path := filepath.Join(dir, tokenListPrefix+now.UTC().Format(tokenListLayout)+".json")
+	// write to a temporary file first, so a failed write never becomes the latest version
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return "", TokenListDiff{}, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", TokenListDiff{}, err
+	}
+	return path, diff, nil
+}
+
+// LatestTokenList returns the path of the latest token list version in the directory, empty if there is none
+func LatestTokenList(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, tokenListPrefix+"*.json"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", nil
+	}
+	sort.Strings(matches)
+	return matches[len(matches)-1], nil
+}
+
+// readTokenListJSON reads a token list version
+func readTokenListJSON(path string) ([]coinListEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []coinListEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode token list %s: %v", path, err)
+	}
+	return entries, nil
+}
+
+// diffTokenLists returns the sorted IDs of the coins added, removed and changed from the old to the new list
+func diffTokenLists(old, new []coinListEntry) (added, removed, changed []string) {
+	oldByID := make(map[string]coinListEntry, len(old))
+	for _, entry := range old {
+		oldByID[entry.ID] = entry
+	}
+	newIDs := make(map[string]bool, len(new))
+	for _, entry := range new {
+		newIDs[entry.ID] = true
+		previous, ok := oldByID[entry.ID]
+		switch {
+		case !ok:
+			added = append(added, entry.ID)
+		case !strings.EqualFold(previous.Symbol, entry.Symbol) || previous.Name != entry.Name || !samePlatforms(previous.Platforms, entry.Platforms):
+			changed = append(changed, entry.ID)
+		}
+	}
+	for _, entry := range old {
+		if !newIDs[entry.ID] {
+			removed = append(removed, entry.ID)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
+
+// samePlatforms compares contract addresses, treating nil and empty as equal
+func samePlatforms(a, b map[string]string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
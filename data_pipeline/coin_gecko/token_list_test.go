@@ -0,0 +1,107 @@
+package coingecko
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoinGeckoClient_RefreshTokenList(t *testing.T) {
+	coinsList := `[
+		{"id": "ethereum", "symbol": "eth", "name": "Ethereum", "platforms": {}},
+		{"id": "usd-coin", "symbol": "usdc", "name": "USDC", "platforms": {"ethereum": "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"}}
+	]`
+	var query string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/coins/list", r.URL.Path)
+		query = r.URL.RawQuery
+		w.Write([]byte(coinsList))
+	}))
+	defer mockServer.Close()
+
+	dir := filepath.Join(t.TempDir(), "token_lists")
+	geckoClient := &CoinGeckoClient{baseUrl: mockServer.URL}
+
+	// the first download has nothing to compare against
+	first, diff, err := geckoClient.RefreshTokenList(context.TODO(), dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "include_platform=true", query)
+	assert.Equal(t, "", diff.Previous)
+	assert.Equal(t, []string{"ethereum", "usd-coin"}, diff.Added)
+
+	latest, err := LatestTokenList(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, first, latest)
+
+	// the downloaded list resolves symbols and keeps the contract addresses
+	symbolToIds, err := getCoinGeckoTokenIds(latest)
+	assert.NoError(t, err)
+	assert.Equal(t, []coinListEntry{{
+		ID:        "usd-coin",
+		Symbol:    "usdc",
+		Name:      "USDC",
+		Platforms: map[string]string{"ethereum": "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"},
+	}}, symbolToIds["usdc"])
+
+	// an unchanged list is not stored again
+	path, diff, err := geckoClient.RefreshTokenList(context.TODO(), dir)
+	assert.NoError(t, err)
+	assert.Equal(t, first, path)
+	assert.True(t, diff.Empty())
+	versions, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	assert.Len(t, versions, 1)
+}
+
+func TestSaveTokenList_Versions(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	first, _, err := saveTokenList(dir, []coinListEntry{
+		{ID: "ethereum", Symbol: "eth", Name: "Ethereum"},
+		{ID: "old-coin", Symbol: "old", Name: "Old"},
+		{ID: "usd-coin", Symbol: "usdc", Name: "USDC"},
+	}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "coins-list-20240401T120000Z.json"), first)
+
+	second, diff, err := saveTokenList(dir, []coinListEntry{
+		{ID: "ethereum", Symbol: "eth", Name: "Ethereum"},
+		{ID: "new-coin", Symbol: "new", Name: "New"},
+		{ID: "usd-coin", Symbol: "usdc", Name: "USDC", Platforms: map[string]string{"base": "0x833589fcd6edb6e08f4c7c32d4f71b54bda02913"}},
+	}, now.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, TokenListDiff{
+		Previous: first,
+		Added:    []string{"new-coin"},
+		Removed:  []string{"old-coin"},
+		Changed:  []string{"usd-coin"},
+	}, diff)
+
+	// both versions are kept, the latest one wins
+	latest, err := LatestTokenList(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, second, latest)
+	_, err = os.Stat(first)
+	assert.NoError(t, err)
+}
+
+func TestNewCoinGeckoClient_LatestTokenList(t *testing.T) {
+	dir := t.TempDir()
+
+	// without a downloaded version the bundled list is used
+	geckoClient, err := NewCoinGeckoClient("", "bundled.csv", ClientOptions{TokenListDir: dir})
+	assert.NoError(t, err)
+	assert.Equal(t, "bundled.csv", geckoClient.TokenListPath())
+
+	path, _, err := saveTokenList(dir, []coinListEntry{{ID: "ethereum", Symbol: "eth", Name: "Ethereum"}}, time.Now())
+	assert.NoError(t, err)
+	geckoClient, err = NewCoinGeckoClient("", "bundled.csv", ClientOptions{TokenListDir: dir})
+	assert.NoError(t, err)
+	assert.Equal(t, path, geckoClient.TokenListPath())
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/0xivanov/blockchain-data-aggregator/models"
 	"github.com/ClickHouse/clickhouse-go/v2"
 )
 
+// volumeScale is the number of decimal places of the Decimal(38, 18) volume columns
+const volumeScale = 18
+
 // ClickHouseDB handles the communication with the ClickHouse database
 type ClickHouseDB struct {
 	dsn  string
@@ -37,19 +41,211 @@ func NewClickHouseDB(dsn, dbName string) (*ClickHouseDB, error) {
 	}, nil
 }
 
-// SaveMarketplaceData saves the given marketplace data to the ClickHouse database
-func (clickHouse *ClickHouseDB) SaveMarketplaceData(ctx context.Context, data []models.MarketplaceData) error {
-	// build the insert query
-	var values string
+// SaveMarketplaceData saves the given marketplace data of the run to the ClickHouse database,
+// with the volumes in fiat currencies other than USD as rows of the marketplace_volumes table
+func (clickHouse *ClickHouseDB) SaveMarketplaceData(ctx context.Context, runID string, data []models.MarketplaceData) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	// the project IDs come from the export, so they are sent as parameters in a single batch
+	tx, err := clickHouse.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin batch: %v", err)
+	}
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO marketplace_data (date, project_id, num_transactions, total_volume_usd, price_overridden, run_id)")
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to prepare insert statement: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, d := range data {
+		date, err := time.Parse(time.DateOnly, d.Date)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("invalid date %q: %v", d.Date, err)
+		}
+		if _, err := stmt.ExecContext(ctx, date, d.ProjectID, int32(d.NumTransactions), d.TotalVolumeUSD.Round(volumeScale), d.PriceOverridden, runID); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to add marketplace data to batch: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to execute insert statement: %v", err)
+	}
+	return clickHouse.saveFiatVolumes(ctx, runID, data)
+}
+
+// saveFiatVolumes saves the volumes in fiat currencies other than USD, one row per day, project and currency
+func (clickHouse *ClickHouseDB) saveFiatVolumes(ctx context.Context, runID string, data []models.MarketplaceData) error {
+	var rows int
 	for _, d := range data {
-		values += fmt.Sprintf(`('%s', '%s', %d, %f) `, d.Date, d.ProjectID, d.NumTransactions, d.TotalVolumeUSD)
+		rows += len(d.Volumes)
+	}
+	if rows == 0 {
+		return nil
 	}
 
-	query := "INSERT INTO marketplace_data (date, project_id, num_transactions, total_volume_usd) VALUES " + values
-	_, err := clickHouse.conn.ExecContext(ctx, query)
+	// the currency codes come from the configuration, so they are sent as parameters in a single batch
+	tx, err := clickHouse.conn.BeginTx(ctx, nil)
 	if err != nil {
+		return fmt.Errorf("failed to begin batch: %v", err)
+	}
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO marketplace_volumes (date, project_id, currency, total_volume, run_id)")
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to prepare fiat volume insert statement: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, d := range data {
+		date, err := time.Parse(time.DateOnly, d.Date)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("invalid date %q: %v", d.Date, err)
+		}
+		for fiat, volume := range d.Volumes {
+			if _, err := stmt.ExecContext(ctx, date, d.ProjectID, fiat, volume.Round(volumeScale), runID); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to add fiat volume to batch: %v", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to execute fiat volume insert statement: %v", err)
+	}
+	return nil
+}
+
+// SaveRejectedRows saves the rows rejected during extraction to the dead-letter table
+func (clickHouse *ClickHouseDB) SaveRejectedRows(ctx context.Context, rows []models.RejectedRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	// the raw records may contain any character, so they are sent as parameters in a single batch
+	tx, err := clickHouse.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin batch: %v", err)
+	}
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO rejected_rows (source, line, reason, record)")
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to prepare insert statement: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row.Source, uint64(row.Line), row.Reason, row.Record); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to add rejected row to batch: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to execute insert statement: %v", err)
 	}
+	return nil
+}
+
+// SavePricesUsed saves the prices the aggregates were computed with and their provenance, so any aggregate can be
+// audited and recomputed. Daily prices have no time and prices of unknown fetch time no fetched_at.
+func (clickHouse *ClickHouseDB) SavePricesUsed(ctx context.Context, prices []models.PriceUsed) error {
+	if len(prices) == 0 {
+		return nil
+	}
+
+	// the symbols come from the transactions, so they are sent as parameters in a single batch
+	tx, err := clickHouse.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin batch: %v", err)
+	}
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO prices_used (run_id, symbol, coin_id, chain, contract, currency, date, time, price, provider, fetched_at, cache_hit)")
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to prepare price insert statement: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, used := range prices {
+		date, err := time.Parse(time.DateOnly, used.Key.Date)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("invalid date %q: %v", used.Key.Date, err)
+		}
+		var at, fetchedAt *time.Time
+		if used.Key.Time != 0 {
+			instant := time.Unix(used.Key.Time, 0).UTC()
+			at = &instant
+		}
+		if !used.FetchedAt.IsZero() {
+			fetched := used.FetchedAt
+			fetchedAt = &fetched
+		}
+		currency := used.Key.Fiat
+		if currency == "" {
+			currency = "usd"
+		}
+		_, err = stmt.ExecContext(ctx, used.RunID, used.Key.Symbol, used.CoinID, used.Key.Chain, used.Key.Contract, currency,
+			date, at, used.Price, used.Provider, fetchedAt, used.CacheHit)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to add price to batch: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to execute price insert statement: %v", err)
+	}
+	return nil
+}
+
+// SaveQuarantinedPrices saves the prices which failed a sanity check and were left out of the aggregates, for review
+func (clickHouse *ClickHouseDB) SaveQuarantinedPrices(ctx context.Context, runID string, prices []models.SuspiciousPrice) error {
+	if len(prices) == 0 {
+		return nil
+	}
 
+	tx, err := clickHouse.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin batch: %v", err)
+	}
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO quarantined_prices (run_id, symbol, coin_id, chain, contract, currency, date, time, price, reference_price, reason)")
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to prepare quarantined price insert statement: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, suspicious := range prices {
+		key := suspicious.Key
+		date, err := time.Parse(time.DateOnly, key.Date)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("invalid date %q: %v", key.Date, err)
+		}
+		var at *time.Time
+		if key.Time != 0 {
+			instant := time.Unix(key.Time, 0).UTC()
+			at = &instant
+		}
+		currency := key.Fiat
+		if currency == "" {
+			currency = "usd"
+		}
+		_, err = stmt.ExecContext(ctx, runID, key.Symbol, key.CoinID, key.Chain, key.Contract, currency,
+			date, at, suspicious.Price, suspicious.Reference, suspicious.Reason)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to add quarantined price to batch: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to execute quarantined price insert statement: %v", err)
+	}
 	return nil
 }
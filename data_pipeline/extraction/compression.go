@@ -0,0 +1,79 @@
+package extraction
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compression is the compression format of a raw export
+type compression string
+
+const (
+	uncompressed compression = ""
+	gzipped      compression = "gzip"
+	zstandard    compression = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// detectCompression detects the compression of a stream from, in order of precedence,
+// its Content-Encoding, the extension of its name or the magic bytes at its start
+func detectCompression(name, contentEncoding string, header []byte) compression {
+	switch strings.ToLower(contentEncoding) {
+	case "gzip", "x-gzip":
+		return gzipped
+	case "zstd":
+		return zstandard
+	case "identity":
+		return uncompressed
+	}
+
+	switch {
+	case strings.HasSuffix(name, ".gz"), strings.HasSuffix(name, ".gzip"):
+		return gzipped
+	case strings.HasSuffix(name, ".zst"), strings.HasSuffix(name, ".zstd"):
+		return zstandard
+	}
+
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return gzipped
+	case bytes.HasPrefix(header, zstdMagic):
+		return zstandard
+	}
+	return uncompressed
+}
+
+// decompress wraps the reader so it yields the decompressed content of the stream.
+// The contentEncoding is the Content-Encoding reported by the storage backend, if any.
+func decompress(reader io.Reader, name, contentEncoding string) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+	// a short stream is not an error here, it simply does not start with a magic number
+	header, _ := buffered.Peek(len(zstdMagic))
+
+	switch detectCompression(name, contentEncoding, header) {
+	case gzipped:
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip stream: %v", err)
+		}
+		return gzipReader, nil
+	case zstandard:
+		zstdReader, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zstd stream: %v", err)
+		}
+		return zstdReader.IOReadCloser(), nil
+	default:
+		return io.NopCloser(buffered), nil
+	}
+}
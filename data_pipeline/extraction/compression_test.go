@@ -0,0 +1,111 @@
+package extraction
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipData(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write([]byte(data))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func zstdData(t *testing.T, data string) []byte {
+	encoder, err := zstd.NewWriter(nil)
+	assert.NoError(t, err)
+	defer encoder.Close()
+	return encoder.EncodeAll([]byte(data), nil)
+}
+
+func TestDetectCompression(t *testing.T) {
+	// Content-Encoding wins over the extension and the extension over the magic bytes
+	assert.Equal(t, gzipped, detectCompression("data.csv", "gzip", nil))
+	assert.Equal(t, zstandard, detectCompression("data.csv.gz", "zstd", nil))
+	assert.Equal(t, uncompressed, detectCompression("data.csv.gz", "identity", gzipMagic))
+	assert.Equal(t, gzipped, detectCompression("data.csv.gz", "", nil))
+	assert.Equal(t, zstandard, detectCompression("data.csv.zst", "", gzipMagic))
+	assert.Equal(t, gzipped, detectCompression("data", "", gzipMagic))
+	assert.Equal(t, zstandard, detectCompression("data", "", zstdMagic))
+	assert.Equal(t, uncompressed, detectCompression("data.csv", "", []byte("ts,p")))
+}
+
+func TestDecompress(t *testing.T) {
+	tests := []struct {
+		name            string
+		contentEncoding string
+		data            []byte
+	}{
+		{"plain.csv", "", []byte(sampleCSVData)},
+		{"sample.csv.gz", "", gzipData(t, sampleCSVData)},
+		{"sample.csv", "gzip", gzipData(t, sampleCSVData)},
+		{"sample.csv.zst", "", zstdData(t, sampleCSVData)},
+		// no hint at all, detected from the magic bytes
+		{"sample", "", gzipData(t, sampleCSVData)},
+		{"sample", "", zstdData(t, sampleCSVData)},
+	}
+
+	for _, test := range tests {
+		reader, err := decompress(bytes.NewReader(test.data), test.name, test.contentEncoding)
+		assert.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.NoError(t, reader.Close())
+		assert.Equal(t, sampleCSVData, string(content), test.name)
+	}
+}
+
+func TestDecompress_NotGzip(t *testing.T) {
+	_, err := decompress(bytes.NewReader([]byte(sampleCSVData)), "sample.csv.gz", "")
+	assert.ErrorContains(t, err, "failed to open gzip stream")
+}
+
+func TestLocalExtractor_Compressed(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "sample.csv.gz"), gzipData(t, sampleCSVData), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "sample.csv.zst"), zstdData(t, sampleCSVData), 0o644))
+
+	for _, name := range []string{"sample.csv.gz", "sample.csv.zst"} {
+		result, err := Collect(context.TODO(), NewLocalExtractor(newDefaultParser(), filepath.Join(dir, name)))
+		assert.NoError(t, err)
+		assert.Len(t, result, 2, name)
+	}
+}
+
+func TestS3Extractor_Compressed(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/blockchain/encoded.csv.gz":
+			// served with a Content-Encoding, the HTTP transport decompresses it already
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gzipData(t, sampleCSVData))
+		case "/blockchain/sample.csv.zst":
+			w.Write(zstdData(t, sampleCSVData))
+		}
+	}))
+	defer mockServer.Close()
+
+	for _, objectName := range []string{"encoded.csv.gz", "sample.csv.zst"} {
+		s3Extractor := NewS3Extractor(newDefaultParser(), S3Options{
+			Endpoint:   mockServer.URL,
+			BucketName: "blockchain",
+			ObjectName: objectName,
+		})
+		result, err := Collect(context.TODO(), s3Extractor)
+		assert.NoError(t, err)
+		assert.Len(t, result, 2, objectName)
+	}
+}
@@ -2,137 +2,103 @@ package extraction
 
 import (
 	"context"
-	"encoding/csv"
 	"fmt"
-	"io"
-	"regexp"
-	"strconv"
-	"time"
+	"sort"
 
 	"cloud.google.com/go/storage"
 	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"google.golang.org/api/iterator"
 )
 
-var (
-	currencySymbolRegex       = regexp.MustCompile(`"currencySymbol":"([^"]+)"`)
-	currencyValueDecimalRegex = regexp.MustCompile(`"currencyValueDecimal":"([^"]+)"`)
-)
-
+// GCPExtractor is a Source reading transactions from CSV objects stored in GCS
 type GCPExtractor struct {
-	client *storage.Client
+	client     *storage.Client
+	parser     *Parser
+	bucketName string
+	// objectName is either a single object, a prefix ending in "/" or a glob pattern
+	objectName string
+	// maximum number of objects read in parallel
+	workers int
 }
 
 // NewGCPExtractor creates a new GCPExtractor.
-func NewGCPExtractor(client *storage.Client) *GCPExtractor {
-	return &GCPExtractor{client}
-}
-
-// ExtractTransactionsFromGCS extracts transactions from a CSV file stored in GCS.
-func (gcpExtractor *GCPExtractor) ExtractTransactionsFromGCS(bucketName, objectName string, ctx context.Context) ([]models.Transaction, error) {
-	reader, err := gcpExtractor.client.Bucket(bucketName).Object(objectName).NewReader(ctx)
-	if err != nil {
-		return nil, err
+func NewGCPExtractor(client *storage.Client, parser *Parser, bucketName, objectName string, workers int) *GCPExtractor {
+	if workers < 1 {
+		workers = 1
+	}
+	return &GCPExtractor{
+		client:     client,
+		parser:     parser,
+		bucketName: bucketName,
+		objectName: objectName,
+		workers:    workers,
 	}
-	defer reader.Close()
-
-	csvReader := csv.NewReader(reader)
-	csvReader.Comma = ','
-
-	return extractTransactions(csvReader)
 }
 
-// Helper function to extract transactions from a CSV file
-func extractTransactions(csvReader *csv.Reader) ([]models.Transaction, error) {
-	var transactions []models.Transaction
+// StreamTransactions streams transactions from every GCS object matching the configured object name.
+func (gcpExtractor *GCPExtractor) StreamTransactions(ctx context.Context, handle func(models.Transaction) error) error {
+	if !isObjectPattern(gcpExtractor.objectName) {
+		return gcpExtractor.streamObject(ctx, gcpExtractor.bucketName, gcpExtractor.objectName, handle)
+	}
 
-	headers, err := csvReader.Read()
+	objectNames, err := gcpExtractor.listObjects(ctx, gcpExtractor.bucketName, gcpExtractor.objectName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+		return err
 	}
-
-	var dateIndex, projectIDIndex, propsIndex, numsIndex int
-	for i, header := range headers {
-		if header == "ts" {
-			dateIndex = i
-		} else if header == "project_id" {
-			projectIDIndex = i
-		} else if header == "props" {
-			propsIndex = i
-		} else if header == "nums" {
-			numsIndex = i
+	return streamObjects(ctx, objectNames, gcpExtractor.workers, handle, func(ctx context.Context, objectName string, handle func(models.Transaction) error) error {
+		if err := gcpExtractor.streamObject(ctx, gcpExtractor.bucketName, objectName, handle); err != nil {
+			return fmt.Errorf("gs://%s/%s: %v", gcpExtractor.bucketName, objectName, err)
 		}
-	}
+		return nil
+	})
+}
+
+// listObjects returns the sorted names of all objects in the bucket matching the pattern
+func (gcpExtractor *GCPExtractor) listObjects(ctx context.Context, bucketName, pattern string) ([]string, error) {
+	it := gcpExtractor.client.Bucket(bucketName).Objects(ctx, &storage.Query{Prefix: objectPrefix(pattern)})
 
+	var objectNames []string
 	for {
-		record, err := csvReader.Read()
-		// read until EOF
-		if err == io.EOF {
+		attrs, err := it.Next()
+		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("error occured during reading csv file: %v", err)
+			return nil, fmt.Errorf("failed to list objects in gs://%s: %v", bucketName, err)
 		}
-
-		dateStr := record[dateIndex]        // "ts"
-		projectID := record[projectIDIndex] // "project_id"
-		props := record[propsIndex]         // "props"
-		nums := record[numsIndex]           // "nums"
-
-		// extract currencySymbol and currencyValueDecimal from props and nums fields
-		currencySymbol, err := extractCurrencySymbol(props)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get currency symbol: %v", err)
+		if matchesObject(pattern, attrs.Name) {
+			objectNames = append(objectNames, attrs.Name)
 		}
-		currencyValueDecimal, err := extractCurrencyValueDecimal(nums)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get currency value symbol: %v", err)
-		}
-
-		// parse the timestamp into a time.Time object
-		parsedTime, err := time.Parse(time.DateTime, dateStr)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse timestamp: %v", err)
-		}
-
-		transactions = append(transactions, models.Transaction{
-			Date:                 parsedTime,
-			ProjectID:            projectID,
-			CurrencySymbol:       currencySymbol,
-			CurrencyValueDecimal: currencyValueDecimal,
-		})
 	}
 
-	if len(transactions) == 0 {
-		return nil, fmt.Errorf("no transactions found in CSV")
+	if len(objectNames) == 0 {
+		return nil, fmt.Errorf("no objects matching %s found in gs://%s", pattern, bucketName)
 	}
-	return transactions, nil
+	sort.Strings(objectNames)
+	return objectNames, nil
 }
 
-// Extract currencySymbol from the props field via regex
-func extractCurrencySymbol(propsString string) (string, error) {
-	matches := currencySymbolRegex.FindStringSubmatch(propsString)
-
-	// check if a match was found
-	if len(matches) > 1 {
-		return matches[1], nil
-	} else {
-		return "", fmt.Errorf("currencySymbol not found in props")
+// ExtractTransactionsFromGCS extracts transactions from a CSV file stored in GCS.
+func (gcpExtractor *GCPExtractor) ExtractTransactionsFromGCS(bucketName, objectName string, ctx context.Context) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+	err := gcpExtractor.streamObject(ctx, bucketName, objectName, func(txn models.Transaction) error {
+		transactions = append(transactions, txn)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return transactions, nil
 }
 
-// Extract currencyValueDecimal from the nums field via regex
-func extractCurrencyValueDecimal(numsString string) (float64, error) {
-	matches := currencyValueDecimalRegex.FindStringSubmatch(numsString)
-
-	// check if a match was found
-	if len(matches) > 1 {
-		// convert the value to a float64
-		currencyValueDecimal, err := strconv.ParseFloat(matches[1], 64)
-		if err != nil {
-			return 0, fmt.Errorf("failed to parse currency value: %v", err)
-		}
-		return currencyValueDecimal, nil
-	} else {
-		return 0, fmt.Errorf("currencyValueDecimal not found in nums")
+// streamObject streams transactions from a single CSV object stored in GCS
+func (gcpExtractor *GCPExtractor) streamObject(ctx context.Context, bucketName, objectName string, handle func(models.Transaction) error) error {
+	// read the stored bytes as they are, decompression is done by the parser
+	reader, err := gcpExtractor.client.Bucket(bucketName).Object(objectName).ReadCompressed(true).NewReader(ctx)
+	if err != nil {
+		return err
 	}
+	defer reader.Close()
+
+	return gcpExtractor.parser.parseStream(fmt.Sprintf("gs://%s/%s", bucketName, objectName), reader.Attrs.ContentEncoding, reader, handle)
 }
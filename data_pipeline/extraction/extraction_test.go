@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,13 +25,13 @@ func TestExtractTransactions_ValidCSV(t *testing.T) {
 			Date:                 time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
 			ProjectID:            "project_1",
 			CurrencySymbol:       "SFL",
-			CurrencyValueDecimal: 100.50,
+			CurrencyValueDecimal: decimal.RequireFromString("100.50"),
 		},
 		{
 			Date:                 time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC),
 			ProjectID:            "project_2",
 			CurrencySymbol:       "MATIC",
-			CurrencyValueDecimal: 200.75,
+			CurrencyValueDecimal: decimal.RequireFromString("200.75"),
 		},
 	}
 
@@ -77,7 +78,7 @@ func TestExtractCurrencySymbol_Missing(t *testing.T) {
 
 func TestExtractCurrencyValueDecimal_Valid(t *testing.T) {
 	nums := `{"currencyValueDecimal":"30000.5"}`
-	expected := 30000.5
+	expected := decimal.RequireFromString("30000.5")
 
 	result, err := extractCurrencyValueDecimal(nums)
 	assert.NoError(t, err)
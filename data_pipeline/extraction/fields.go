@@ -0,0 +1,175 @@
+package extraction
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FieldMapping declares where the transaction fields are found in the columns of an export.
+// A path starts with the column name followed by the keys leading to the value inside the
+// column's JSON document, e.g. "props.currencySymbol" or "props.currency.symbol" for nested objects.
+// A path without keys, e.g. "symbol", reads the plain value of the column.
+type FieldMapping struct {
+	Symbol string
+	Amount string
+	// Chain and Contract are the optional paths of the network and the token contract address of the currency.
+	// Transactions without them are priced by symbol.
+	Chain    string
+	Contract string
+	// Extra maps names of additional fields to capture onto their paths
+	Extra map[string]string
+}
+
+// DefaultFieldMapping returns the mapping matching the layout of the marketplace exports
+func DefaultFieldMapping() FieldMapping {
+	return FieldMapping{
+		Symbol:   "props.currencySymbol",
+		Amount:   "nums.currencyValueDecimal",
+		Chain:    "props.chain",
+		Contract: "props.currencyAddress",
+	}
+}
+
+// splitPath splits a field path into its column and the keys inside the column's JSON document
+func splitPath(path string) (string, []string, error) {
+	parts := strings.Split(path, ".")
+	if parts[0] == "" {
+		return "", nil, fmt.Errorf("invalid field path %q, expected <column>[.<key>...]", path)
+	}
+	return parts[0], parts[1:], nil
+}
+
+// jsonDocuments lazily decodes the JSON columns of a single record
+type jsonDocuments struct {
+	// values keyed by column, JSON documents are either encoded strings or already decoded objects
+	values  map[string]interface{}
+	decoded map[string]interface{}
+}
+
+func newJSONDocuments(values map[string]interface{}) *jsonDocuments {
+	return &jsonDocuments{
+		values:  values,
+		decoded: make(map[string]interface{}),
+	}
+}
+
+// lookupOptional returns the scalar at the given path as a string, empty if the path is absent or null
+func (docs *jsonDocuments) lookupOptional(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	column, keys, err := splitPath(path)
+	if err != nil {
+		return "", err
+	}
+	if docs.values[column] == nil {
+		return "", nil
+	}
+	if len(keys) == 0 {
+		return docs.lookup(path)
+	}
+
+	doc, err := docs.document(column)
+	if err != nil {
+		return "", err
+	}
+	value := doc
+	for _, key := range keys {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", nil
+		}
+		if value = object[key]; value == nil {
+			return "", nil
+		}
+	}
+	return scalarString(value, strings.Join(keys, ".")+" in "+column)
+}
+
+// document returns the decoded JSON document of the column
+func (docs *jsonDocuments) document(column string) (interface{}, error) {
+	if doc, ok := docs.decoded[column]; ok {
+		return doc, nil
+	}
+
+	var doc interface{}
+	switch v := docs.values[column].(type) {
+	case string:
+		var err error
+		doc, err = decodeJSON(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s column: %v", column, err)
+		}
+	case map[string]interface{}:
+		doc = v
+	default:
+		return nil, fmt.Errorf("%s column is not a JSON document", column)
+	}
+	docs.decoded[column] = doc
+	return doc, nil
+}
+
+// lookup returns the scalar at the given path as a string
+func (docs *jsonDocuments) lookup(path string) (string, error) {
+	column, keys, err := splitPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	value := docs.values[column]
+	if len(keys) == 0 {
+		if value == nil {
+			return "", fmt.Errorf("%s is empty", column)
+		}
+		return scalarString(value, column)
+	}
+
+	doc, err := docs.document(column)
+	if err != nil {
+		return "", err
+	}
+
+	value = doc
+	for _, key := range keys {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("%s not found in %s", strings.Join(keys, "."), column)
+		}
+		if value, ok = object[key]; !ok {
+			return "", fmt.Errorf("%s not found in %s", strings.Join(keys, "."), column)
+		}
+	}
+	if value == nil {
+		return "", fmt.Errorf("%s is null in %s", strings.Join(keys, "."), column)
+	}
+	return scalarString(value, strings.Join(keys, ".")+" in "+column)
+}
+
+// Helper function to format a scalar JSON value as a string, the name describes the value in errors
+func scalarString(value interface{}, name string) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("%s is not a scalar value", name)
+	}
+}
+
+// Helper function to decode a JSON document, keeping numbers in their original textual form
+func decodeJSON(raw string) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	decoder.UseNumber()
+
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
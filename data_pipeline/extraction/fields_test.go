@@ -0,0 +1,152 @@
+package extraction
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractCurrencySymbol_Whitespace(t *testing.T) {
+	result, err := extractCurrencySymbol(`{ "currencySymbol" : "BTC" }`)
+	assert.NoError(t, err)
+	assert.Equal(t, "BTC", result)
+}
+
+func TestExtractCurrencySymbol_EscapedQuotes(t *testing.T) {
+	result, err := extractCurrencySymbol(`{"currencySymbol":"\"BTC\""}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `"BTC"`, result)
+}
+
+func TestExtractCurrencySymbol_MalformedJSON(t *testing.T) {
+	_, err := extractCurrencySymbol(`{"currencySymbol":"BTC"`)
+	assert.ErrorContains(t, err, "failed to decode props column")
+}
+
+func TestExtractCurrencyValueDecimal_Unquoted(t *testing.T) {
+	result, err := extractCurrencyValueDecimal(`{"currencyValueDecimal":30000.5}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "30000.5", result.String())
+}
+
+func TestExtractCurrencyValueDecimal_Object(t *testing.T) {
+	_, err := extractCurrencyValueDecimal(`{"currencyValueDecimal":{"value":"1"}}`)
+	assert.ErrorContains(t, err, "is not a scalar value")
+}
+
+func TestNewParser_InvalidPath(t *testing.T) {
+	_, err := NewParser(ParserOptions{Fields: FieldMapping{Symbol: ".currencySymbol", Amount: "nums.currencyValueDecimal"}})
+	assert.ErrorContains(t, err, `invalid field path ".currencySymbol"`)
+}
+
+func TestParser_CustomFieldMapping(t *testing.T) {
+	csvContent := `ts,project_id,props,nums
+2024-04-01 00:00:00,project_1,"{""currency"":{""symbol"":""ETH""},""txHash"":""0xabc""}","{""amount"":1.5}"
+`
+	parser, err := NewParser(ParserOptions{Fields: FieldMapping{
+		Symbol: "props.currency.symbol",
+		Amount: "nums.amount",
+		Extra:  map[string]string{"txHash": "props.txHash"},
+	}})
+	assert.NoError(t, err)
+
+	var result []models.Transaction
+	err = parser.streamTransactions("test.csv", csv.NewReader(strings.NewReader(csvContent)), func(txn models.Transaction) error {
+		result = append(result, txn)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Transaction{
+		{
+			Date:                 time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+			ProjectID:            "project_1",
+			CurrencySymbol:       "ETH",
+			CurrencyValueDecimal: decimal.RequireFromString("1.5"),
+			Extra:                map[string]string{"txHash": "0xabc"},
+		},
+	}, result)
+}
+
+func TestParser_MissingExtraColumns(t *testing.T) {
+	csvContent := `ts,project_id,props,nums
+2024-04-01 00:00:00,project_1,"{}","{}"
+`
+	extra := map[string]string{"zone": "zone", "amount_eur": "amount_eur", "buyer": "buyer", "fee": "fee"}
+	parser, err := NewParser(ParserOptions{Fields: FieldMapping{Symbol: "props.currencySymbol", Amount: "nums.currencyValueDecimal", Extra: extra}})
+	assert.NoError(t, err)
+
+	// the extra columns are listed in the order of their names, not of the map
+	err = parser.streamTransactions("test.csv", csv.NewReader(strings.NewReader(csvContent)), func(txn models.Transaction) error { return nil })
+	assert.EqualError(t, err, "header is missing required columns: amount_eur, buyer, fee, zone")
+}
+
+func TestParser_UnknownColumn(t *testing.T) {
+	csvContent := `ts,project_id,props,nums
+2024-04-01 00:00:00,project_1,"{}","{}"
+`
+	parser, err := NewParser(ParserOptions{Fields: FieldMapping{Symbol: "meta.symbol", Amount: "nums.amount"}})
+	assert.NoError(t, err)
+
+	err = parser.streamTransactions("test.csv", csv.NewReader(strings.NewReader(csvContent)), func(txn models.Transaction) error { return nil })
+	assert.ErrorContains(t, err, "header is missing required columns: meta")
+}
+
+func TestParser_ChainAndContract(t *testing.T) {
+	csvContent := `ts,project_id,props,nums
+2024-04-01 00:00:00,project_1,"{""currencySymbol"":""USDC"",""chain"":""polygon"",""currencyAddress"":""0x3c499c542cEF5E3811e1192ce70d8cC03d5c3359""}","{""currencyValueDecimal"":""1.5""}"
+2024-04-01 00:00:00,project_1,"{""currencySymbol"":""ETH"",""chain"":null}","{""currencyValueDecimal"":""2""}"
+`
+	parser, err := NewParser(ParserOptions{Fields: DefaultFieldMapping()})
+	assert.NoError(t, err)
+
+	var result []models.Transaction
+	err = parser.streamTransactions("test.csv", csv.NewReader(strings.NewReader(csvContent)), func(txn models.Transaction) error {
+		result = append(result, txn)
+		return nil
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, result, 2) {
+		assert.Equal(t, "polygon", result[0].Chain)
+		assert.Equal(t, "0x3c499c542cEF5E3811e1192ce70d8cC03d5c3359", result[0].ContractAddress)
+		// absent or null fields leave the transaction to be priced by symbol
+		assert.Equal(t, "", result[1].Chain)
+		assert.Equal(t, "", result[1].ContractAddress)
+	}
+}
+
+func TestParser_ContractInOptionalColumn(t *testing.T) {
+	fields := DefaultFieldMapping()
+	fields.Chain = "chain"
+	fields.Contract = "token_address"
+	parser, err := NewParser(ParserOptions{Fields: fields})
+	assert.NoError(t, err)
+
+	// the columns of optional fields are not required
+	csvContent := `ts,project_id,props,nums
+2024-04-01 00:00:00,project_1,"{""currencySymbol"":""ETH""}","{""currencyValueDecimal"":""2""}"
+`
+	var result []models.Transaction
+	err = parser.streamTransactions("test.csv", csv.NewReader(strings.NewReader(csvContent)), func(txn models.Transaction) error {
+		result = append(result, txn)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "", result[0].ContractAddress)
+
+	csvContent = `ts,project_id,props,nums,chain,token_address
+2024-04-01 00:00:00,project_1,"{""currencySymbol"":""USDC""}","{""currencyValueDecimal"":""2""}",base,0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913
+`
+	result = nil
+	err = parser.streamTransactions("test.csv", csv.NewReader(strings.NewReader(csvContent)), func(txn models.Transaction) error {
+		result = append(result, txn)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "base", result[0].Chain)
+	assert.Equal(t, "0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913", result[0].ContractAddress)
+}
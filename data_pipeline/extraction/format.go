@@ -0,0 +1,164 @@
+package extraction
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Format is the file format of a raw transaction export
+type Format string
+
+const (
+	// CSV exports have a header row, the props and nums columns hold JSON documents
+	CSV Format = "csv"
+	// NDJSON exports have one JSON object per line, the props and nums keys hold
+	// either nested objects or JSON documents encoded as strings
+	NDJSON Format = "ndjson"
+	// Parquet exports have the same columns as CSV exports
+	Parquet Format = "parquet"
+)
+
+// detectFormat detects the format of an export from its name, ignoring any compression extension.
+// Unknown extensions are read as CSV.
+func detectFormat(name string) Format {
+	for _, ext := range []string{".gz", ".gzip", ".zst", ".zstd"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+
+	switch {
+	case strings.HasSuffix(name, ".ndjson"), strings.HasSuffix(name, ".jsonl"):
+		return NDJSON
+	case strings.HasSuffix(name, ".parquet"):
+		return Parquet
+	default:
+		return CSV
+	}
+}
+
+// record is a single record of an export, whatever its format
+type record struct {
+	// line or row number the record starts at
+	line int
+	// values keyed by the column names of the export
+	values map[string]interface{}
+	// raw is the record as found in the export, kept for the dead-letter sink
+	raw string
+}
+
+// malformedRecordError is returned by a recordReader for a record that cannot be read at all,
+// reading can go on with the next record
+type malformedRecordError struct {
+	line int
+	raw  string
+	err  error
+}
+
+func (e *malformedRecordError) Error() string {
+	return e.err.Error()
+}
+
+// recordReader reads the records of an export one at a time
+type recordReader interface {
+	// columns returns the column names of the export, or nil if they are only known per record
+	columns() []string
+	// next returns the next record, io.EOF after the last one
+	next() (record, error)
+}
+
+// csvRecords reads the records of a CSV export
+type csvRecords struct {
+	csvReader *csv.Reader
+	headers   []string
+}
+
+func newCSVRecords(csvReader *csv.Reader) (*csvRecords, error) {
+	headers, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	return &csvRecords{
+		csvReader: csvReader,
+		headers:   headers,
+	}, nil
+}
+
+func (records *csvRecords) columns() []string {
+	return records.headers
+}
+
+func (records *csvRecords) next() (record, error) {
+	fields, err := records.csvReader.Read()
+	if err == io.EOF {
+		return record{}, err
+	}
+	if parseErr, ok := err.(*csv.ParseError); ok {
+		// the record itself is malformed, e.g. it has the wrong number of fields
+		return record{}, &malformedRecordError{line: parseErr.StartLine, raw: strings.Join(fields, ","), err: err}
+	}
+	if err != nil {
+		return record{}, fmt.Errorf("error occured during reading csv file: %v", err)
+	}
+
+	line, _ := records.csvReader.FieldPos(0)
+	values := make(map[string]interface{}, len(fields))
+	for i, field := range fields {
+		values[records.headers[i]] = field
+	}
+	return record{
+		line:   line,
+		values: values,
+		raw:    strings.Join(fields, ","),
+	}, nil
+}
+
+// maxNDJSONLineSize bounds the memory used by a single line of an NDJSON export
+const maxNDJSONLineSize = 16 * 1024 * 1024
+
+// ndjsonRecords reads the records of a newline-delimited JSON export
+type ndjsonRecords struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONRecords(reader io.Reader) *ndjsonRecords {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxNDJSONLineSize)
+	return &ndjsonRecords{scanner: scanner}
+}
+
+func (records *ndjsonRecords) columns() []string {
+	return nil
+}
+
+func (records *ndjsonRecords) next() (record, error) {
+	for records.scanner.Scan() {
+		records.line++
+		line := bytes.TrimSpace(records.scanner.Bytes())
+		// blank lines, e.g. a trailing newline, are not records
+		if len(line) == 0 {
+			continue
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		var values map[string]interface{}
+		if err := decoder.Decode(&values); err != nil {
+			return record{}, &malformedRecordError{line: records.line, raw: string(line), err: fmt.Errorf("invalid JSON: %v", err)}
+		}
+		return record{
+			line:   records.line,
+			values: values,
+			raw:    string(line),
+		}, nil
+	}
+
+	if err := records.scanner.Err(); err != nil {
+		return record{}, fmt.Errorf("error occured during reading ndjson file: %v", err)
+	}
+	return record{}, io.EOF
+}
@@ -0,0 +1,150 @@
+package extraction
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/parquet-go/parquet-go"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+var sampleNDJSONData = `{"ts":"2024-04-01 00:00:00","project_id":"project_1","props":{"currencySymbol":"SFL"},"nums":{"currencyValueDecimal":"100.50"}}
+{"ts":"2024-04-02 00:00:00","project_id":"project_2","props":"{\"currencySymbol\":\"MATIC\"}","nums":{"currencyValueDecimal":200.75}}
+`
+
+var expectedTransactions = []models.Transaction{
+	{
+		Date:                 time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		ProjectID:            "project_1",
+		CurrencySymbol:       "SFL",
+		CurrencyValueDecimal: decimal.RequireFromString("100.50"),
+	},
+	{
+		Date:                 time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC),
+		ProjectID:            "project_2",
+		CurrencySymbol:       "MATIC",
+		CurrencyValueDecimal: decimal.RequireFromString("200.75"),
+	},
+}
+
+func TestDetectFormat(t *testing.T) {
+	assert.Equal(t, CSV, detectFormat("exports/2024-04-01.csv"))
+	assert.Equal(t, CSV, detectFormat("exports/2024-04-01.csv.gz"))
+	assert.Equal(t, NDJSON, detectFormat("exports/2024-04-01.ndjson"))
+	assert.Equal(t, NDJSON, detectFormat("exports/2024-04-01.jsonl.zst"))
+	assert.Equal(t, Parquet, detectFormat("exports/2024-04-01.parquet"))
+	assert.Equal(t, CSV, detectFormat("exports/2024-04-01"))
+}
+
+func TestParser_NDJSON(t *testing.T) {
+	var result []models.Transaction
+	err := newDefaultParser().parseStream("sample.ndjson", "", strings.NewReader(sampleNDJSONData), func(txn models.Transaction) error {
+		result = append(result, txn)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, expectedTransactions, result)
+}
+
+func TestParser_NDJSONMalformedLines(t *testing.T) {
+	ndjsonContent := `{"ts":"2024-04-01 00:00:00","project_id":"project_1","props":{"currencySymbol":"SFL"},"nums":{"currencyValueDecimal":1}}
+{"ts":"2024-04-01 00:00:00",
+{"ts":"2024-04-01 00:00:00","props":{"currencySymbol":"SFL"},"nums":{"currencyValueDecimal":1}}
+`
+	sink := &MemoryRejectSink{}
+	parser, err := NewParser(ParserOptions{Fields: DefaultFieldMapping(), Format: NDJSON, ErrorPolicy: Quarantine, Rejects: sink})
+	assert.NoError(t, err)
+
+	err = parser.parseStream("sample", "", strings.NewReader(ndjsonContent), func(txn models.Transaction) error { return nil })
+	assert.NoError(t, err)
+	assert.Len(t, sink.Rows, 2)
+	assert.Equal(t, 2, sink.Rows[0].Line)
+	assert.Contains(t, sink.Rows[0].Reason, "invalid JSON")
+	assert.Equal(t, 3, sink.Rows[1].Line)
+	assert.Equal(t, "record is missing required columns: project_id", sink.Rows[1].Reason)
+}
+
+// parquetTransaction mirrors the columns of a warehouse export
+type parquetTransaction struct {
+	Ts        time.Time `parquet:"ts,timestamp(millisecond)"`
+	ProjectID string    `parquet:"project_id"`
+	Symbol    string    `parquet:"symbol"`
+	Amount    float64   `parquet:"amount"`
+}
+
+func TestParser_Parquet(t *testing.T) {
+	var buf bytes.Buffer
+	err := parquet.Write(&buf, []parquetTransaction{
+		{Ts: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), ProjectID: "project_1", Symbol: "SFL", Amount: 100.50},
+		{Ts: time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), ProjectID: "project_2", Symbol: "MATIC", Amount: 200.75},
+	})
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "sample.parquet")
+	assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+
+	// the warehouse export has plain columns instead of JSON documents
+	parser, err := NewParser(ParserOptions{Fields: FieldMapping{Symbol: "symbol", Amount: "amount"}})
+	assert.NoError(t, err)
+
+	result, err := Collect(context.TODO(), NewLocalExtractor(parser, path))
+	assert.NoError(t, err)
+	// doubles are read at their shortest representation, without trailing zeros
+	expected := append([]models.Transaction(nil), expectedTransactions...)
+	expected[0].CurrencyValueDecimal = decimal.RequireFromString("100.5")
+	assert.Equal(t, expected, result)
+}
+
+func TestParser_ParquetDecimal(t *testing.T) {
+	type decimalTransaction struct {
+		Ts        time.Time `parquet:"ts,timestamp(millisecond)"`
+		ProjectID string    `parquet:"project_id"`
+		Symbol    string    `parquet:"symbol"`
+		Amount    int64     `parquet:"amount,decimal(2:18)"`
+	}
+	var buf bytes.Buffer
+	err := parquet.Write(&buf, []decimalTransaction{
+		{Ts: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), ProjectID: "project_1", Symbol: "SFL", Amount: 10050},
+		{Ts: time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), ProjectID: "project_2", Symbol: "MATIC", Amount: 20075},
+	})
+	assert.NoError(t, err)
+
+	parser, err := NewParser(ParserOptions{Fields: FieldMapping{Symbol: "symbol", Amount: "amount"}})
+	assert.NoError(t, err)
+
+	// the unscaled values are scaled by the column
+	var result []models.Transaction
+	err = parser.parseStream("sample.parquet", "", &buf, func(txn models.Transaction) error {
+		result = append(result, txn)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, expectedTransactions, result)
+}
+
+func TestUnscaledDecimal(t *testing.T) {
+	// -12345 as a fixed length big-endian two's complement
+	assert.Equal(t, "-12345", unscaledDecimal(parquet.FixedLenByteArrayValue([]byte{0xff, 0xff, 0xcf, 0xc7})).String())
+	assert.Equal(t, "12345", unscaledDecimal(parquet.FixedLenByteArrayValue([]byte{0x00, 0x00, 0x30, 0x39})).String())
+	assert.Equal(t, "-5", unscaledDecimal(parquet.Int32Value(-5)).String())
+}
+
+func TestParser_ParquetMissingColumns(t *testing.T) {
+	var buf bytes.Buffer
+	err := parquet.Write(&buf, []parquetTransaction{{ProjectID: "project_1"}})
+	assert.NoError(t, err)
+
+	err = newDefaultParser().parseStream("sample.parquet", "", &buf, func(txn models.Transaction) error { return nil })
+	assert.EqualError(t, err, "header is missing required columns: props, nums")
+}
+
+func TestNewParser_UnknownFormat(t *testing.T) {
+	_, err := NewParser(ParserOptions{Fields: DefaultFieldMapping(), Format: "xml"})
+	assert.ErrorContains(t, err, `unknown format "xml"`)
+}
@@ -0,0 +1,34 @@
+package extraction
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+)
+
+// LocalExtractor is a Source reading transactions from a CSV file on the local filesystem
+type LocalExtractor struct {
+	parser *Parser
+	path   string
+}
+
+// NewLocalExtractor creates a new LocalExtractor.
+func NewLocalExtractor(parser *Parser, path string) *LocalExtractor {
+	return &LocalExtractor{
+		parser: parser,
+		path:   path,
+	}
+}
+
+// StreamTransactions streams transactions from the configured local file.
+func (localExtractor *LocalExtractor) StreamTransactions(ctx context.Context, handle func(models.Transaction) error) error {
+	f, err := os.Open(localExtractor.path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", localExtractor.path, err)
+	}
+	defer f.Close()
+
+	return localExtractor.parser.parseStream(localExtractor.path, "", f, handle)
+}
@@ -0,0 +1,70 @@
+package extraction
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+)
+
+// isObjectPattern reports whether the object name selects several objects,
+// either as a prefix ending in "/" or as a glob pattern
+func isObjectPattern(objectName string) bool {
+	return strings.HasSuffix(objectName, "/") || strings.ContainsAny(objectName, "*?[")
+}
+
+// objectPrefix returns the literal part of the pattern, which is used to narrow down the listing
+func objectPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?["); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// matchesObject reports whether the object name is selected by the prefix or glob pattern
+func matchesObject(pattern, objectName string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		// skip the "directory" placeholder objects some tools create
+		return strings.HasPrefix(objectName, pattern) && objectName != pattern
+	}
+	matched, err := path.Match(pattern, objectName)
+	return err == nil && matched
+}
+
+// streamObjects streams the given objects using at most `workers` parallel calls to stream.
+// Calls to handle are serialized, so it does not need to be safe for concurrent use.
+// Every failed object is reported, so the returned error identifies all bad objects rather than just the first one.
+func streamObjects(ctx context.Context, objectNames []string, workers int, handle func(models.Transaction) error,
+	stream func(ctx context.Context, objectName string, handle func(models.Transaction) error) error) error {
+	errs := make([]error, len(objectNames))
+
+	var mu sync.Mutex
+	serializedHandle := func(txn models.Transaction) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return handle(txn)
+	}
+
+	// bounded worker pool consuming object indexes
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = stream(ctx, objectNames[i], serializedHandle)
+			}
+		}()
+	}
+	for i := range objectNames {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,72 @@
+package extraction
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsObjectPattern(t *testing.T) {
+	assert.False(t, isObjectPattern("sample_data.csv"))
+	assert.True(t, isObjectPattern("exports/"))
+	assert.True(t, isObjectPattern("exports/2024-04-*.csv"))
+	assert.True(t, isObjectPattern("exports/shard-?.csv"))
+}
+
+func TestObjectPrefix(t *testing.T) {
+	assert.Equal(t, "exports/2024-04-", objectPrefix("exports/2024-04-*.csv"))
+	assert.Equal(t, "exports/", objectPrefix("exports/"))
+}
+
+func TestMatchesObject(t *testing.T) {
+	assert.True(t, matchesObject("exports/", "exports/2024-04-01.csv"))
+	assert.False(t, matchesObject("exports/", "exports/"))
+	assert.False(t, matchesObject("exports/", "other/2024-04-01.csv"))
+
+	assert.True(t, matchesObject("exports/2024-04-*.csv", "exports/2024-04-01.csv"))
+	assert.False(t, matchesObject("exports/2024-04-*.csv", "exports/2024-05-01.csv"))
+	assert.False(t, matchesObject("exports/*.csv", "exports/nested/2024-04-01.csv"))
+}
+
+func TestStreamObjects_MergesEveryObject(t *testing.T) {
+	objectNames := []string{"shard-1.csv", "shard-2.csv", "shard-3.csv"}
+
+	var calls int32
+	var result []models.Transaction
+	err := streamObjects(context.TODO(), objectNames, 2,
+		func(txn models.Transaction) error {
+			result = append(result, txn)
+			return nil
+		},
+		func(ctx context.Context, objectName string, handle func(models.Transaction) error) error {
+			atomic.AddInt32(&calls, 1)
+			return handle(models.Transaction{ProjectID: objectName})
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), calls)
+	assert.ElementsMatch(t, []models.Transaction{
+		{ProjectID: "shard-1.csv"},
+		{ProjectID: "shard-2.csv"},
+		{ProjectID: "shard-3.csv"},
+	}, result)
+}
+
+func TestStreamObjects_ReportsEveryBadObject(t *testing.T) {
+	objectNames := []string{"shard-1.csv", "shard-2.csv", "shard-3.csv"}
+
+	err := streamObjects(context.TODO(), objectNames, 3,
+		func(txn models.Transaction) error { return nil },
+		func(ctx context.Context, objectName string, handle func(models.Transaction) error) error {
+			if objectName == "shard-2.csv" {
+				return handle(models.Transaction{ProjectID: objectName})
+			}
+			return fmt.Errorf("%s: failed to parse timestamp", objectName)
+		})
+	assert.ErrorContains(t, err, "shard-1.csv: failed to parse timestamp")
+	assert.ErrorContains(t, err, "shard-3.csv: failed to parse timestamp")
+	assert.NotContains(t, err.Error(), "shard-2.csv")
+}
@@ -0,0 +1,155 @@
+package extraction
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/shopspring/decimal"
+)
+
+// parquetRecords reads the rows of a Parquet export.
+// Parquet files need random access, so the stream is spooled to a temporary file first.
+type parquetRecords struct {
+	file    *os.File
+	reader  *parquet.Reader
+	headers []string
+	// timestamp units of the columns annotated as timestamps, indexed like headers
+	timestampUnits []time.Duration
+	// scales of the columns annotated as decimals, by column index
+	decimalScales map[int]int32
+	rows          []parquet.Row
+	row           int
+}
+
+func newParquetRecords(reader io.Reader) (*parquetRecords, error) {
+	file, err := os.CreateTemp("", "transactions-*.parquet")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary parquet file: %v", err)
+	}
+	records := &parquetRecords{file: file, decimalScales: make(map[int]int32), rows: make([]parquet.Row, 1)}
+
+	size, err := io.Copy(file, reader)
+	if err != nil {
+		records.close()
+		return nil, fmt.Errorf("failed to spool parquet file: %v", err)
+	}
+	parquetFile, err := parquet.OpenFile(file, size)
+	if err != nil {
+		records.close()
+		return nil, fmt.Errorf("failed to open parquet file: %v", err)
+	}
+	records.reader = parquet.NewReader(parquetFile)
+
+	schema := parquetFile.Schema()
+	for column, path := range schema.Columns() {
+		records.headers = append(records.headers, strings.Join(path, "."))
+
+		var unit time.Duration
+		leaf, _ := schema.Lookup(path...)
+		logicalType := leaf.Node.Type().LogicalType()
+		if logicalType != nil && logicalType.Decimal != nil {
+			records.decimalScales[column] = logicalType.Decimal.Scale
+		}
+		if logicalType != nil && logicalType.Timestamp != nil {
+			switch {
+			case logicalType.Timestamp.Unit.Millis != nil:
+				unit = time.Millisecond
+			case logicalType.Timestamp.Unit.Micros != nil:
+				unit = time.Microsecond
+			default:
+				unit = time.Nanosecond
+			}
+		}
+		records.timestampUnits = append(records.timestampUnits, unit)
+	}
+	return records, nil
+}
+
+func (records *parquetRecords) columns() []string {
+	return records.headers
+}
+
+func (records *parquetRecords) next() (record, error) {
+	n, err := records.reader.ReadRows(records.rows)
+	if n == 0 {
+		if err == nil || err == io.EOF {
+			return record{}, io.EOF
+		}
+		return record{}, fmt.Errorf("error occured during reading parquet file: %v", err)
+	}
+	records.row++
+
+	values := make(map[string]interface{}, len(records.headers))
+	var raw []string
+	for _, value := range records.rows[0] {
+		column := value.Column()
+		values[records.headers[column]] = records.convert(column, value)
+		raw = append(raw, value.String())
+	}
+	return record{
+		line:   records.row,
+		values: values,
+		raw:    strings.Join(raw, ","),
+	}, nil
+}
+
+// convert turns a parquet value into the Go value the parser expects for the column
+func (records *parquetRecords) convert(column int, value parquet.Value) interface{} {
+	if value.IsNull() {
+		return nil
+	}
+	if unit := records.timestampUnits[column]; unit != 0 {
+		return time.Unix(0, value.Int64()*int64(unit)).UTC()
+	}
+	// decimals are stored unscaled
+	if scale, ok := records.decimalScales[column]; ok {
+		return json.Number(decimal.NewFromBigInt(unscaledDecimal(value), -scale).StringFixed(scale))
+	}
+
+	switch value.Kind() {
+	case parquet.Boolean:
+		return value.Boolean()
+	case parquet.Int32:
+		return json.Number(strconv.FormatInt(int64(value.Int32()), 10))
+	case parquet.Int64:
+		return json.Number(strconv.FormatInt(value.Int64(), 10))
+	case parquet.Float:
+		return json.Number(strconv.FormatFloat(float64(value.Float()), 'f', -1, 32))
+	case parquet.Double:
+		return json.Number(strconv.FormatFloat(value.Double(), 'f', -1, 64))
+	default:
+		return string(value.ByteArray())
+	}
+}
+
+// unscaledDecimal returns the unscaled value of a decimal, stored as an integer or as a big-endian two's complement
+func unscaledDecimal(value parquet.Value) *big.Int {
+	switch value.Kind() {
+	case parquet.Int32:
+		return big.NewInt(int64(value.Int32()))
+	case parquet.Int64:
+		return big.NewInt(value.Int64())
+	}
+	bytes := value.ByteArray()
+	unscaled := new(big.Int).SetBytes(bytes)
+	if len(bytes) > 0 && bytes[0]&0x80 != 0 {
+		unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(bytes)*8)))
+	}
+	return unscaled
+}
+
+// close releases the reader and removes the temporary file
+func (records *parquetRecords) close() {
+	if records.reader != nil {
+		records.reader.Close()
+	}
+	records.file.Close()
+	os.Remove(records.file.Name())
+}
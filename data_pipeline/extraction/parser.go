@@ -0,0 +1,386 @@
+package extraction
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/shopspring/decimal"
+)
+
+// ParserOptions configures how a Parser reads raw records
+type ParserOptions struct {
+	Fields FieldMapping
+	// Columns maps the column names used by the parser, "ts", "project_id" and the columns
+	// referenced by the field paths, onto the header names of the export, e.g. "ts" -> "timestamp".
+	// Unmapped columns are expected under their own name.
+	Columns map[string]string
+	// ErrorPolicy decides what happens to malformed records, FailFast when empty
+	ErrorPolicy ErrorPolicy
+	// Rejects receives the malformed records under the Quarantine policy
+	Rejects RejectSink
+	// MaxRejects aborts the extraction once more records are rejected, zero means no limit
+	MaxRejects int
+	// Format of the export, detected from the file extension when empty
+	Format Format
+	// TimestampLayouts are tried in order to parse the ts column. Besides Go reference time layouts
+	// LayoutRFC3339, LayoutUnix and LayoutUnixMilli are accepted. Defaults to time.DateTime.
+	TimestampLayouts []string
+	// Location is the time zone of timestamps that do not carry one, UTC when nil
+	Location *time.Location
+}
+
+// Parser turns the records of a raw transaction export into transactions
+type Parser struct {
+	fields     FieldMapping
+	columns    map[string]string
+	format     Format
+	timestamps *timestampParser
+	tracker    *rejectTracker
+}
+
+// NewParser creates a new Parser from the given options.
+func NewParser(options ParserOptions) (*Parser, error) {
+	switch options.Format {
+	case "", CSV, NDJSON, Parquet:
+	default:
+		return nil, fmt.Errorf("unknown format %q", options.Format)
+	}
+
+	switch options.ErrorPolicy {
+	case "", FailFast, Skip:
+	case Quarantine:
+		if options.Rejects == nil {
+			return nil, fmt.Errorf("the quarantine error policy requires a reject sink")
+		}
+	default:
+		return nil, fmt.Errorf("unknown error policy %q", options.ErrorPolicy)
+	}
+
+	parser := &Parser{
+		fields:     options.Fields,
+		columns:    options.Columns,
+		format:     options.Format,
+		timestamps: newTimestampParser(options.TimestampLayouts, options.Location),
+		tracker: &rejectTracker{
+			policy:     options.ErrorPolicy,
+			sink:       options.Rejects,
+			maxRejects: options.MaxRejects,
+		},
+	}
+	for _, path := range parser.paths() {
+		if _, _, err := splitPath(path); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range parser.optionalPaths() {
+		if _, _, err := splitPath(path); err != nil {
+			return nil, err
+		}
+	}
+	return parser, nil
+}
+
+// Counts returns the number of records accepted and rejected by the parser so far
+func (parser *Parser) Counts() (accepted, rejected int) {
+	return parser.tracker.counts()
+}
+
+// newDefaultParser creates a fail-fast parser for the layout of the marketplace exports
+func newDefaultParser() *Parser {
+	parser, _ := NewParser(ParserOptions{Fields: DefaultFieldMapping()})
+	return parser
+}
+
+// Helper function to parse transactions from a raw, possibly compressed stream.
+// The name identifies the stream in errors, contentEncoding is the encoding reported by the backend, if any.
+func (parser *Parser) parseStream(name, contentEncoding string, reader io.Reader, handle func(models.Transaction) error) error {
+	decompressed, err := decompress(reader, name, contentEncoding)
+	if err != nil {
+		return err
+	}
+	defer decompressed.Close()
+
+	format := parser.format
+	if format == "" {
+		format = detectFormat(name)
+	}
+
+	switch format {
+	case CSV:
+		return parser.parseCSV(name, decompressed, handle)
+	case NDJSON:
+		return parser.streamRecords(name, newNDJSONRecords(decompressed), handle)
+	case Parquet:
+		records, err := newParquetRecords(decompressed)
+		if err != nil {
+			return err
+		}
+		defer records.close()
+		return parser.streamRecords(name, records, handle)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// Helper function to parse transactions from a raw CSV stream, the name identifies the stream in errors
+func (parser *Parser) parseCSV(name string, reader io.Reader, handle func(models.Transaction) error) error {
+	csvReader := csv.NewReader(reader)
+	csvReader.Comma = ','
+
+	return parser.streamTransactions(name, csvReader, handle)
+}
+
+// Helper function to extract all transactions from a CSV file into memory
+func extractTransactions(csvReader *csv.Reader) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+	err := newDefaultParser().streamTransactions("csv", csvReader, func(txn models.Transaction) error {
+		transactions = append(transactions, txn)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// Helper function to stream transactions from a CSV file one record at a time
+func (parser *Parser) streamTransactions(name string, csvReader *csv.Reader, handle func(models.Transaction) error) error {
+	records, err := newCSVRecords(csvReader)
+	if err != nil {
+		return err
+	}
+	return parser.streamRecords(name, records, handle)
+}
+
+// Helper function to stream transactions from the records of an export one at a time
+func (parser *Parser) streamRecords(name string, records recordReader, handle func(models.Transaction) error) error {
+	// every column read by the parser has to be present, report all missing ones at once
+	if columns := records.columns(); columns != nil {
+		if missing := parser.missingColumns(columns); len(missing) > 0 {
+			return fmt.Errorf("header is missing required columns: %s", strings.Join(missing, ", "))
+		}
+	}
+
+	for {
+		rec, err := records.next()
+		// read until EOF
+		if err == io.EOF {
+			break
+		}
+
+		var txn models.Transaction
+		if malformed, ok := err.(*malformedRecordError); ok {
+			rec.line, rec.raw = malformed.line, malformed.raw
+		} else if err != nil {
+			return err
+		} else {
+			txn, err = parser.parseRecord(rec)
+		}
+
+		// a malformed record, its fate is decided by the error policy
+		if err != nil {
+			rejectErr := parser.tracker.reject(models.RejectedRow{
+				Source: name,
+				Line:   rec.line,
+				Reason: err.Error(),
+				Record: rec.raw,
+			})
+			if rejectErr != nil {
+				return rejectErr
+			}
+			continue
+		}
+
+		if err := handle(txn); err != nil {
+			return err
+		}
+		parser.tracker.accept()
+	}
+	return nil
+}
+
+// missingColumns returns the columns read by the parser which are not among the given ones
+func (parser *Parser) missingColumns(columns []string) []string {
+	present := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		present[column] = true
+	}
+
+	var missing []string
+	for _, column := range parser.requiredColumns() {
+		header := parser.header(column)
+		if present[header] {
+			continue
+		}
+		if header != column {
+			missing = append(missing, fmt.Sprintf("%s (mapped to %s)", column, header))
+		} else {
+			missing = append(missing, column)
+		}
+	}
+	return missing
+}
+
+// requiredColumns returns every column read by the parser, without duplicates
+func (parser *Parser) requiredColumns() []string {
+	columns := []string{"ts", "project_id"}
+	seen := map[string]bool{"ts": true, "project_id": true}
+	for _, path := range parser.paths() {
+		column, _, _ := splitPath(path)
+		if !seen[column] {
+			seen[column] = true
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// parseRecord turns a single record into a transaction
+func (parser *Parser) parseRecord(rec record) (models.Transaction, error) {
+	// records of formats without a header are checked one by one
+	values := make(map[string]interface{})
+	var missing []string
+	for _, column := range parser.requiredColumns() {
+		value, ok := rec.values[parser.header(column)]
+		if !ok {
+			missing = append(missing, parser.header(column))
+		}
+		values[column] = value
+	}
+	if len(missing) > 0 {
+		return models.Transaction{}, fmt.Errorf("record is missing required columns: %s", strings.Join(missing, ", "))
+	}
+	// the columns of optional fields may be absent
+	for _, path := range parser.optionalPaths() {
+		column, _, _ := splitPath(path)
+		if _, ok := values[column]; !ok {
+			values[column] = rec.values[parser.header(column)]
+		}
+	}
+	docs := newJSONDocuments(values)
+
+	projectID, err := docs.lookup("project_id")
+	if err != nil {
+		return models.Transaction{}, fmt.Errorf("failed to get project id: %v", err)
+	}
+
+	// extract currencySymbol and currencyValueDecimal from the JSON columns
+	currencySymbol, err := docs.lookup(parser.fields.Symbol)
+	if err != nil {
+		return models.Transaction{}, fmt.Errorf("failed to get currency symbol: %v", err)
+	}
+	amount, err := docs.lookup(parser.fields.Amount)
+	if err != nil {
+		return models.Transaction{}, fmt.Errorf("failed to get currency value symbol: %v", err)
+	}
+	currencyValueDecimal, err := decimal.NewFromString(amount)
+	if err != nil {
+		return models.Transaction{}, fmt.Errorf("failed to parse currency value: %v", err)
+	}
+
+	// the network and contract address of the currency are optional
+	chain, err := docs.lookupOptional(parser.fields.Chain)
+	if err != nil {
+		return models.Transaction{}, fmt.Errorf("failed to get chain: %v", err)
+	}
+	contract, err := docs.lookupOptional(parser.fields.Contract)
+	if err != nil {
+		return models.Transaction{}, fmt.Errorf("failed to get contract address: %v", err)
+	}
+
+	var extra map[string]string
+	for name, path := range parser.fields.Extra {
+		value, err := docs.lookup(path)
+		if err != nil {
+			return models.Transaction{}, fmt.Errorf("failed to get %s: %v", name, err)
+		}
+		if extra == nil {
+			extra = make(map[string]string, len(parser.fields.Extra))
+		}
+		extra[name] = value
+	}
+
+	// parse the timestamp into a time.Time object, typed formats may carry it already
+	var parsedTime time.Time
+	if ts, ok := values["ts"].(time.Time); ok {
+		parsedTime = ts
+	} else {
+		dateStr, err := docs.lookup("ts")
+		if err != nil {
+			return models.Transaction{}, fmt.Errorf("failed to parse timestamp: %v", err)
+		}
+		parsedTime, err = parser.timestamps.parse(dateStr)
+		if err != nil {
+			return models.Transaction{}, fmt.Errorf("failed to parse timestamp: %v", err)
+		}
+	}
+
+	return models.Transaction{
+		Date:                 parsedTime,
+		ProjectID:            projectID,
+		CurrencySymbol:       currencySymbol,
+		CurrencyValueDecimal: currencyValueDecimal,
+		Chain:                chain,
+		ContractAddress:      contract,
+		Extra:                extra,
+	}, nil
+}
+
+// header returns the name under which the column appears in the CSV header
+func (parser *Parser) header(column string) string {
+	if header, ok := parser.columns[column]; ok {
+		return header
+	}
+	return column
+}
+
+// paths returns every field path the parser reads, the extra fields sorted by name so errors list them in a stable order
+func (parser *Parser) paths() []string {
+	names := make([]string, 0, len(parser.fields.Extra))
+	for name := range parser.fields.Extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	paths := []string{parser.fields.Symbol, parser.fields.Amount}
+	for _, name := range names {
+		paths = append(paths, parser.fields.Extra[name])
+	}
+	return paths
+}
+
+// optionalPaths returns the field paths of the optional fields which are set
+func (parser *Parser) optionalPaths() []string {
+	var paths []string
+	for _, path := range []string{parser.fields.Chain, parser.fields.Contract} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Extract currencySymbol from the props field
+func extractCurrencySymbol(propsString string) (string, error) {
+	return newJSONDocuments(map[string]interface{}{"props": propsString}).lookup(DefaultFieldMapping().Symbol)
+}
+
+// Extract currencyValueDecimal from the nums field
+func extractCurrencyValueDecimal(numsString string) (decimal.Decimal, error) {
+	value, err := newJSONDocuments(map[string]interface{}{"nums": numsString}).lookup(DefaultFieldMapping().Amount)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+
+	// parse the value exactly, floats lose the digits of large or precise amounts
+	currencyValueDecimal, err := decimal.NewFromString(value)
+	if err != nil {
+		return decimal.Decimal{}, fmt.Errorf("failed to parse currency value: %v", err)
+	}
+	return currencyValueDecimal, nil
+}
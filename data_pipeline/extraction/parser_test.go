@@ -0,0 +1,57 @@
+package extraction
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParser_MissingHeaders(t *testing.T) {
+	csvContent := `project_id,nums
+project_1,"{""currencyValueDecimal"":""1""}"
+`
+	err := newDefaultParser().streamTransactions("sample.csv", csv.NewReader(strings.NewReader(csvContent)), func(txn models.Transaction) error { return nil })
+	assert.EqualError(t, err, "header is missing required columns: ts, props")
+}
+
+func TestParser_ColumnMapping(t *testing.T) {
+	csvContent := `timestamp,app_id,properties,nums
+2024-04-01 00:00:00,project_1,"{""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""100.50""}"
+`
+	parser, err := NewParser(ParserOptions{
+		Fields:  DefaultFieldMapping(),
+		Columns: map[string]string{"ts": "timestamp", "project_id": "app_id", "props": "properties"},
+	})
+	assert.NoError(t, err)
+
+	var result []models.Transaction
+	err = parser.streamTransactions("sample.csv", csv.NewReader(strings.NewReader(csvContent)), func(txn models.Transaction) error {
+		result = append(result, txn)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Transaction{
+		{
+			Date:                 time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+			ProjectID:            "project_1",
+			CurrencySymbol:       "SFL",
+			CurrencyValueDecimal: decimal.RequireFromString("100.50"),
+		},
+	}, result)
+}
+
+func TestParser_MissingMappedHeader(t *testing.T) {
+	parser, err := NewParser(ParserOptions{
+		Fields:  DefaultFieldMapping(),
+		Columns: map[string]string{"ts": "timestamp", "project_id": "app_id"},
+	})
+	assert.NoError(t, err)
+
+	err = parser.streamTransactions("sample.csv", csv.NewReader(strings.NewReader(sampleCSVData)), func(txn models.Transaction) error { return nil })
+	assert.EqualError(t, err, "header is missing required columns: ts (mapped to timestamp), project_id (mapped to app_id)")
+}
@@ -0,0 +1,171 @@
+package extraction
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+)
+
+// ErrorPolicy decides what happens to records that cannot be turned into transactions
+type ErrorPolicy string
+
+const (
+	// FailFast aborts the extraction at the first malformed record
+	FailFast ErrorPolicy = "fail-fast"
+	// Skip drops malformed records and carries on
+	Skip ErrorPolicy = "skip"
+	// Quarantine hands malformed records to a RejectSink and carries on
+	Quarantine ErrorPolicy = "quarantine"
+)
+
+// RejectSink receives the records rejected under the Quarantine policy
+type RejectSink interface {
+	Reject(row models.RejectedRow) error
+}
+
+// FileRejectSink writes rejected records to a CSV dead-letter file
+type FileRejectSink struct {
+	file      *os.File
+	csvWriter *csv.Writer
+}
+
+// NewFileRejectSink creates the dead-letter file at the given path, truncating any previous run.
+func NewFileRejectSink(path string) (*FileRejectSink, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dead-letter file: %v", err)
+	}
+
+	csvWriter := csv.NewWriter(file)
+	if err := csvWriter.Write([]string{"source", "line", "reason", "record"}); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &FileRejectSink{
+		file:      file,
+		csvWriter: csvWriter,
+	}, nil
+}
+
+// Reject appends a single rejected record to the file
+func (sink *FileRejectSink) Reject(row models.RejectedRow) error {
+	return sink.csvWriter.Write([]string{row.Source, strconv.Itoa(row.Line), row.Reason, row.Record})
+}
+
+// Close flushes the buffered records and closes the file
+func (sink *FileRejectSink) Close() error {
+	sink.csvWriter.Flush()
+	if err := sink.csvWriter.Error(); err != nil {
+		sink.file.Close()
+		return err
+	}
+	return sink.file.Close()
+}
+
+// MemoryRejectSink keeps rejected records in memory, so they can be saved in bulk after the run
+type MemoryRejectSink struct {
+	Rows []models.RejectedRow
+}
+
+// Reject appends a single rejected record
+func (sink *MemoryRejectSink) Reject(row models.RejectedRow) error {
+	sink.Rows = append(sink.Rows, row)
+	return nil
+}
+
+// DiscardRejectSink drops rejected records, for runs which only count them
+type DiscardRejectSink struct{}
+
+// Reject drops a single rejected record
+func (DiscardRejectSink) Reject(row models.RejectedRow) error {
+	return nil
+}
+
+// BatchRejectSink hands rejected records to a save function in batches, so they are not all kept in memory
+type BatchRejectSink struct {
+	size int
+	// save receives the rows of a batch, they are only valid during the call
+	save func(rows []models.RejectedRow) error
+	rows []models.RejectedRow
+}
+
+// NewBatchRejectSink creates a new BatchRejectSink saving every size records
+func NewBatchRejectSink(size int, save func(rows []models.RejectedRow) error) (*BatchRejectSink, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("reject batch size must be positive, got %d", size)
+	}
+	return &BatchRejectSink{size: size, save: save}, nil
+}
+
+// Reject appends a single rejected record, saving the batch once it is full
+func (sink *BatchRejectSink) Reject(row models.RejectedRow) error {
+	sink.rows = append(sink.rows, row)
+	if len(sink.rows) < sink.size {
+		return nil
+	}
+	return sink.Flush()
+}
+
+// Flush saves the records of the incomplete batch
+func (sink *BatchRejectSink) Flush() error {
+	if len(sink.rows) == 0 {
+		return nil
+	}
+	err := sink.save(sink.rows)
+	sink.rows = sink.rows[:0]
+	return err
+}
+
+// rejectTracker applies the error policy and counts the accepted and rejected records.
+// A single tracker is shared by all objects of a run, which may be parsed in parallel.
+type rejectTracker struct {
+	policy     ErrorPolicy
+	sink       RejectSink
+	maxRejects int
+
+	mu       sync.Mutex
+	accepted int
+	rejected int
+}
+
+// accept records a successfully parsed record
+func (tracker *rejectTracker) accept() {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	tracker.accepted++
+}
+
+// reject applies the error policy to a malformed record.
+// A non-nil error means the extraction has to be aborted.
+func (tracker *rejectTracker) reject(row models.RejectedRow) error {
+	if tracker.policy == FailFast || tracker.policy == "" {
+		return fmt.Errorf("%s line %d: %s", row.Source, row.Line, row.Reason)
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	tracker.rejected++
+
+	if tracker.policy == Quarantine {
+		if err := tracker.sink.Reject(row); err != nil {
+			return fmt.Errorf("failed to quarantine %s line %d: %v", row.Source, row.Line, err)
+		}
+	}
+	// zero means no limit
+	if tracker.maxRejects > 0 && tracker.rejected > tracker.maxRejects {
+		return fmt.Errorf("too many rejected rows: more than %d, last at %s line %d: %s",
+			tracker.maxRejects, row.Source, row.Line, row.Reason)
+	}
+	return nil
+}
+
+// counts returns the number of accepted and rejected records so far
+func (tracker *rejectTracker) counts() (int, int) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	return tracker.accepted, tracker.rejected
+}
@@ -0,0 +1,154 @@
+package extraction
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var malformedCSVData = `ts,project_id,props,nums
+2024-04-01 00:00:00,project_1,"{""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""100.50""}"
+not a timestamp,project_1,"{""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""1""}"
+2024-04-01 00:00:00,project_1,"{""currencySymbol"":""SFL""}","{}"
+2024-04-02 00:00:00,project_2,"{""currencySymbol"":""MATIC""}","{""currencyValueDecimal"":""200.75""}"
+`
+
+func streamWithPolicy(t *testing.T, options ParserOptions) (*Parser, []models.Transaction, error) {
+	options.Fields = DefaultFieldMapping()
+	parser, err := NewParser(options)
+	assert.NoError(t, err)
+
+	var result []models.Transaction
+	err = parser.streamTransactions("sample.csv", csv.NewReader(strings.NewReader(malformedCSVData)), func(txn models.Transaction) error {
+		result = append(result, txn)
+		return nil
+	})
+	return parser, result, err
+}
+
+func TestParser_FailFast(t *testing.T) {
+	_, _, err := streamWithPolicy(t, ParserOptions{ErrorPolicy: FailFast})
+	assert.ErrorContains(t, err, "sample.csv line 3: failed to parse timestamp")
+}
+
+func TestParser_Skip(t *testing.T) {
+	parser, result, err := streamWithPolicy(t, ParserOptions{ErrorPolicy: Skip})
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+
+	accepted, rejected := parser.Counts()
+	assert.Equal(t, 2, accepted)
+	assert.Equal(t, 2, rejected)
+}
+
+func TestParser_Quarantine(t *testing.T) {
+	sink := &MemoryRejectSink{}
+	_, result, err := streamWithPolicy(t, ParserOptions{ErrorPolicy: Quarantine, Rejects: sink})
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+
+	assert.Len(t, sink.Rows, 2)
+	assert.Equal(t, "sample.csv", sink.Rows[0].Source)
+	assert.Equal(t, 3, sink.Rows[0].Line)
+	assert.Contains(t, sink.Rows[0].Reason, "failed to parse timestamp")
+	assert.Contains(t, sink.Rows[0].Record, "not a timestamp")
+	assert.Equal(t, 4, sink.Rows[1].Line)
+	assert.Contains(t, sink.Rows[1].Reason, "currencyValueDecimal not found in nums")
+}
+
+func TestParser_AllRejectedShard(t *testing.T) {
+	shards := map[string]string{
+		"shard-1.csv": sampleCSVData,
+		"shard-2.csv": `ts,project_id,props,nums
+not a timestamp,project_1,"{""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""1""}"
+`,
+		"shard-3.csv": sampleCSVData,
+	}
+	parser, err := NewParser(ParserOptions{Fields: DefaultFieldMapping(), ErrorPolicy: Skip})
+	assert.NoError(t, err)
+
+	// a shard without a single valid record does not fail the others
+	var handled int
+	err = streamObjects(context.TODO(), []string{"shard-1.csv", "shard-2.csv", "shard-3.csv"}, 2,
+		func(txn models.Transaction) error {
+			handled++
+			return nil
+		},
+		func(ctx context.Context, objectName string, handle func(models.Transaction) error) error {
+			return parser.streamTransactions(objectName, csv.NewReader(strings.NewReader(shards[objectName])), handle)
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, handled)
+	accepted, rejected := parser.Counts()
+	assert.Equal(t, 4, accepted)
+	assert.Equal(t, 1, rejected)
+}
+
+func TestParser_MaxRejects(t *testing.T) {
+	_, _, err := streamWithPolicy(t, ParserOptions{ErrorPolicy: Skip, MaxRejects: 1})
+	assert.ErrorContains(t, err, "too many rejected rows: more than 1, last at sample.csv line 4")
+}
+
+func TestParser_WrongFieldCount(t *testing.T) {
+	csvContent := `ts,project_id,props,nums
+2024-04-01 00:00:00,project_1
+2024-04-01 00:00:00,project_1,"{""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""1""}"
+`
+	sink := &MemoryRejectSink{}
+	parser, err := NewParser(ParserOptions{Fields: DefaultFieldMapping(), ErrorPolicy: Quarantine, Rejects: sink})
+	assert.NoError(t, err)
+
+	err = parser.streamTransactions("sample.csv", csv.NewReader(strings.NewReader(csvContent)), func(txn models.Transaction) error { return nil })
+	assert.NoError(t, err)
+	assert.Len(t, sink.Rows, 1)
+	assert.Equal(t, 2, sink.Rows[0].Line)
+	assert.Contains(t, sink.Rows[0].Reason, "wrong number of fields")
+}
+
+func TestNewParser_QuarantineWithoutSink(t *testing.T) {
+	_, err := NewParser(ParserOptions{Fields: DefaultFieldMapping(), ErrorPolicy: Quarantine})
+	assert.ErrorContains(t, err, "requires a reject sink")
+}
+
+func TestBatchRejectSink(t *testing.T) {
+	var batches [][]int
+	sink, err := NewBatchRejectSink(2, func(rows []models.RejectedRow) error {
+		var lines []int
+		for _, row := range rows {
+			lines = append(lines, row.Line)
+		}
+		batches = append(batches, lines)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	for line := 1; line <= 3; line++ {
+		assert.NoError(t, sink.Reject(models.RejectedRow{Source: "sample.csv", Line: line}))
+	}
+	assert.Equal(t, [][]int{{1, 2}}, batches)
+	assert.NoError(t, sink.Flush())
+	assert.NoError(t, sink.Flush())
+	assert.Equal(t, [][]int{{1, 2}, {3}}, batches)
+
+	_, err = NewBatchRejectSink(0, nil)
+	assert.ErrorContains(t, err, "must be positive")
+}
+
+func TestFileRejectSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rejected.csv")
+	sink, err := NewFileRejectSink(path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, sink.Reject(models.RejectedRow{Source: "sample.csv", Line: 3, Reason: "bad, row", Record: "a,b"}))
+	assert.NoError(t, sink.Close())
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "source,line,reason,record\nsample.csv,3,\"bad, row\",\"a,b\"\n", string(content))
+}
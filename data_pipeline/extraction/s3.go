@@ -0,0 +1,203 @@
+package extraction
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+)
+
+const (
+	amzDateFormat = "20060102T150405Z"
+	// hex encoded SHA256 of an empty payload, GET requests have no body
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Options holds the connection details for an S3-compatible object store
+type S3Options struct {
+	// Endpoint is the base URL of the store, e.g. http://localhost:9000 for MinIO.
+	// When empty the AWS endpoint for the region is used.
+	Endpoint   string
+	Region     string
+	AccessKey  string
+	SecretKey  string
+	BucketName string
+	ObjectName string
+}
+
+// S3Extractor is a Source reading transactions from a CSV object stored in an S3-compatible store
+type S3Extractor struct {
+	parser     *Parser
+	options    S3Options
+	httpClient *http.Client
+	// injected function for testing purposes
+	nowFunc func() time.Time
+}
+
+// newS3Client returns the HTTP client of the extractor. Objects may take long to download, so there is no overall
+// timeout, but connecting and waiting for the response headers are bounded so a stalled store fails the run.
+func newS3Client() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	return &http.Client{Transport: transport}
+}
+
+// NewS3Extractor creates a new S3Extractor.
+func NewS3Extractor(parser *Parser, options S3Options) *S3Extractor {
+	if options.Region == "" {
+		options.Region = "us-east-1"
+	}
+	if options.Endpoint == "" {
+		options.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", options.Region)
+	}
+	return &S3Extractor{
+		parser:     parser,
+		options:    options,
+		httpClient: newS3Client(),
+		nowFunc:    time.Now,
+	}
+}
+
+// StreamTransactions streams transactions from the configured S3 object.
+func (s3Extractor *S3Extractor) StreamTransactions(ctx context.Context, handle func(models.Transaction) error) error {
+	resp, err := s3Extractor.getObject(ctx, s3Extractor.options.BucketName, s3Extractor.options.ObjectName)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	contentEncoding := resp.Header.Get("Content-Encoding")
+	if resp.Uncompressed {
+		// already decompressed by the HTTP transport
+		contentEncoding = "identity"
+	}
+	return s3Extractor.parser.parseStream(fmt.Sprintf("s3://%s/%s", s3Extractor.options.BucketName, s3Extractor.options.ObjectName), contentEncoding, resp.Body, handle)
+}
+
+// getObject downloads an object using a path-style GET request
+func (s3Extractor *S3Extractor) getObject(ctx context.Context, bucketName, objectName string) (*http.Response, error) {
+	objectUrl := strings.TrimSuffix(s3Extractor.options.Endpoint, "/") + "/" + uriEncode(bucketName) + "/" + escapeKey(objectName)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, objectUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	s3Extractor.sign(req)
+
+	resp, err := s3Extractor.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("request for s3://%s/%s failed with status: %v", bucketName, objectName, resp.Status)
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 authorization header to the request.
+// Every header already set on the request is included in the signature.
+// Requests are sent anonymously when no credentials are configured.
+func (s3Extractor *S3Extractor) sign(req *http.Request) {
+	if s3Extractor.options.AccessKey == "" {
+		return
+	}
+
+	now := s3Extractor.nowFunc().UTC()
+	amzDate := now.Format(amzDateFormat)
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", now.Format("20060102"), s3Extractor.options.Region)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", emptyPayloadHash)
+
+	// canonical headers must be lowercase and sorted, host is always signed
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	// derive the signing key from the secret key and the credential scope
+	key := hmacSHA256([]byte("AWS4"+s3Extractor.options.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s3Extractor.options.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Extractor.options.AccessKey, scope, signedHeaders, signature))
+}
+
+// Helper function to build the sorted, URI encoded query string used in the signature
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(key)+"="+uriEncode(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Helper function to URI encode every segment of an object key, keeping the slashes between them
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// Helper function to URI encode a value the way SigV4 expects (spaces as %20, not +)
+func uriEncode(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,27 @@
+package extraction
+
+import (
+	"context"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+)
+
+// Source is a backend from which transactions can be extracted.
+// Transactions are streamed to the handle function one at a time, so the memory used
+// does not grow with the size of the input. Streaming stops at the first error returned by handle.
+type Source interface {
+	StreamTransactions(ctx context.Context, handle func(models.Transaction) error) error
+}
+
+// Collect reads every transaction of the source into memory
+func Collect(ctx context.Context, source Source) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+	err := source.StreamTransactions(ctx, func(txn models.Transaction) error {
+		transactions = append(transactions, txn)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
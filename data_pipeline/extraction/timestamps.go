@@ -0,0 +1,74 @@
+package extraction
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Special timestamp layouts besides the Go reference time layouts
+const (
+	// RFC3339 timestamps, with or without fractional seconds
+	LayoutRFC3339 = "rfc3339"
+	// LayoutUnix is the number of seconds since the Unix epoch
+	LayoutUnix = "unix"
+	// LayoutUnixMilli is the number of milliseconds since the Unix epoch
+	LayoutUnixMilli = "unixmilli"
+)
+
+// timestampParser parses timestamps in any of the configured layouts
+type timestampParser struct {
+	layouts []string
+	// location of timestamps that do not carry a zone themselves
+	location *time.Location
+}
+
+// newTimestampParser creates a parser trying the layouts in order.
+// It defaults to time.DateTime in UTC, the layout of the marketplace exports.
+func newTimestampParser(layouts []string, location *time.Location) *timestampParser {
+	if len(layouts) == 0 {
+		layouts = []string{time.DateTime}
+	}
+	if location == nil {
+		location = time.UTC
+	}
+	return &timestampParser{
+		layouts:  layouts,
+		location: location,
+	}
+}
+
+// parse parses the timestamp with the first layout matching it
+func (parser *timestampParser) parse(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range parser.layouts {
+		if parsed, err := parser.parseLayout(layout, value); err == nil {
+			return parsed, nil
+		}
+	}
+
+	if len(parser.layouts) == 1 {
+		_, err := parser.parseLayout(parser.layouts[0], value)
+		return time.Time{}, err
+	}
+	return time.Time{}, fmt.Errorf("timestamp %q does not match any of the layouts %s", value, strings.Join(parser.layouts, ", "))
+}
+
+func (parser *timestampParser) parseLayout(layout, value string) (time.Time, error) {
+	switch layout {
+	case LayoutRFC3339:
+		return time.Parse(time.RFC3339Nano, value)
+	case LayoutUnix, LayoutUnixMilli:
+		epoch, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s timestamp %q", layout, value)
+		}
+		if layout == LayoutUnixMilli {
+			return time.UnixMilli(epoch).UTC(), nil
+		}
+		return time.Unix(epoch, 0).UTC(), nil
+	default:
+		return time.ParseInLocation(layout, value, parser.location)
+	}
+}
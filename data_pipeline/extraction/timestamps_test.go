@@ -0,0 +1,69 @@
+package extraction
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampParser_Default(t *testing.T) {
+	parsed, err := newTimestampParser(nil, nil).parse("2024-04-01 13:30:00")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 4, 1, 13, 30, 0, 0, time.UTC), parsed)
+
+	_, err = newTimestampParser(nil, nil).parse("2024-04-01T13:30:00Z")
+	assert.ErrorContains(t, err, "cannot parse")
+}
+
+func TestTimestampParser_Layouts(t *testing.T) {
+	parser := newTimestampParser([]string{LayoutRFC3339, LayoutUnixMilli, "02/01/2006 15:04"}, nil)
+	expected := time.Date(2024, 4, 1, 13, 30, 0, 0, time.UTC)
+
+	for _, value := range []string{"2024-04-01T13:30:00Z", "2024-04-01T15:30:00.000+02:00", "1711978200000", "01/04/2024 13:30"} {
+		parsed, err := parser.parse(value)
+		assert.NoError(t, err, value)
+		assert.True(t, expected.Equal(parsed), value)
+	}
+
+	_, err := parser.parse("yesterday")
+	assert.EqualError(t, err, `timestamp "yesterday" does not match any of the layouts rfc3339, unixmilli, 02/01/2006 15:04`)
+}
+
+func TestTimestampParser_Unix(t *testing.T) {
+	parsed, err := newTimestampParser([]string{LayoutUnix}, nil).parse("1711978200")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 4, 1, 13, 30, 0, 0, time.UTC), parsed)
+}
+
+func TestTimestampParser_SourceLocation(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+	parser := newTimestampParser([]string{time.DateTime, LayoutRFC3339}, newYork)
+
+	// timestamps without a zone are in the source location
+	parsed, err := parser.parse("2024-04-01 22:00:00")
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2024, 4, 2, 2, 0, 0, 0, time.UTC).Equal(parsed))
+
+	// timestamps carrying a zone keep it
+	parsed, err = parser.parse("2024-04-01T22:00:00Z")
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2024, 4, 1, 22, 0, 0, 0, time.UTC).Equal(parsed))
+}
+
+func TestParser_EpochTimestampsInNDJSON(t *testing.T) {
+	ndjsonContent := `{"ts":1711978200,"project_id":"project_1","props":{"currencySymbol":"SFL"},"nums":{"currencyValueDecimal":1}}`
+	parser, err := NewParser(ParserOptions{Fields: DefaultFieldMapping(), TimestampLayouts: []string{LayoutUnix}})
+	assert.NoError(t, err)
+
+	var result []models.Transaction
+	err = parser.parseStream("sample.ndjson", "", strings.NewReader(ndjsonContent), func(txn models.Transaction) error {
+		result = append(result, txn)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 4, 1, 13, 30, 0, 0, time.UTC), result[0].Date)
+}
@@ -0,0 +1,138 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+)
+
+// binanceInvalidSymbol is the error code Binance answers unknown trading pairs with
+const binanceInvalidSymbol = -1121
+
+// errUnlistedPair is returned for trading pairs Binance does not list
+var errUnlistedPair = errors.New("pair is not listed")
+
+// binanceError is the body of a failed Binance API request
+type binanceError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// BinanceProvider is a PriceProvider reading daily klines (candlesticks) from the Binance spot API.
+// A currency is priced by the opening price of its pair with the quote asset on the day, which matches
+// the 00:00 UTC snapshot of the CoinGecko history. Keys with a Time are priced by the opening price of the
+// minute kline at that time instead. Currencies without a pair and keys with a token contract are left out.
+type BinanceProvider struct {
+	baseUrl string
+	// quoteAsset is the USD stablecoin the currencies are paired with, e.g. USDT
+	quoteAsset string
+}
+
+// NewBinanceProvider creates a new BinanceProvider pricing currencies against the given quote asset, USDT when empty.
+func NewBinanceProvider(quoteAsset string) *BinanceProvider {
+	if quoteAsset == "" {
+		quoteAsset = "USDT"
+	}
+	return &BinanceProvider{
+		baseUrl:    "https://api.binance.com/api/v3",
+		quoteAsset: strings.ToUpper(quoteAsset),
+	}
+}
+
+// Name returns the name of the provider
+func (provider *BinanceProvider) Name() string {
+	return "binance"
+}
+
+// GetPrices fetches the daily kline of every requested key
+func (provider *BinanceProvider) GetPrices(ctx context.Context, keys []models.PriceKey) (models.PriceMap, error) {
+	prices := make(models.PriceMap)
+	// pairs Binance does not list are not asked for again
+	unlisted := make(map[string]bool)
+	for _, key := range keys {
+		// currencies are only paired with USD stablecoins, by their symbol
+		if _, ok := prices[key]; ok || key.Fiat != "" || key.HasContract() {
+			continue
+		}
+		pair := strings.ToUpper(key.Symbol) + provider.quoteAsset
+		if unlisted[pair] {
+			continue
+		}
+		date, err := time.Parse(time.DateOnly, key.Date)
+		if err != nil {
+			return prices, fmt.Errorf("invalid price date %q for %s: %v", key.Date, key.Symbol, err)
+		}
+
+		interval := "1d"
+		if key.Time != 0 {
+			interval, date = "1m", time.Unix(key.Time, 0).Truncate(time.Minute)
+		}
+
+		price, ok, err := provider.getOpenPrice(ctx, pair, interval, date)
+		if err == errUnlistedPair {
+			unlisted[pair] = true
+			continue
+		}
+		if err != nil {
+			return prices, fmt.Errorf("failed to get price for %s on %s: %v", key.Symbol, key.Date, err)
+		}
+		if ok {
+			prices[key] = price
+		}
+	}
+	return prices, nil
+}
+
+// binanceClient sends the kline requests, a stalled connection fails instead of hanging the run
+var binanceClient = &http.Client{Timeout: 30 * time.Second}
+
+// getOpenPrice returns the opening price of the pair in the kline of the interval starting at the given time,
+// ok is false if it was not traded then. errUnlistedPair is returned if Binance does not list the pair at all.
+func (provider *BinanceProvider) getOpenPrice(ctx context.Context, pair, interval string, date time.Time) (price float64, ok bool, err error) {
+	url := fmt.Sprintf("%s/klines?symbol=%s&interval=%s&startTime=%d&limit=1", provider.baseUrl, pair, interval, date.UnixMilli())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, false, err
+	}
+
+	resp, err := binanceClient.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr binanceError
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Code == binanceInvalidSymbol {
+			return 0, false, errUnlistedPair
+		}
+		return 0, false, fmt.Errorf("request failed with status: %v", resp.Status)
+	}
+
+	// every kline is an array of open time, open, high, low, close, volume, ...
+	var klines [][]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&klines); err != nil {
+		return 0, false, err
+	}
+	// no trading in that interval, e.g. before the pair was listed
+	if len(klines) == 0 || len(klines[0]) < 2 {
+		return 0, false, nil
+	}
+	openTime, _ := klines[0][0].(float64)
+	if int64(openTime) != date.UnixMilli() {
+		return 0, false, nil
+	}
+	open, _ := klines[0][1].(string)
+	price, err = strconv.ParseFloat(open, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid open price %q: %v", open, err)
+	}
+	return price, true, nil
+}
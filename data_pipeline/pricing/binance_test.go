@@ -0,0 +1,89 @@
+package pricing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// 2024-04-01T00:00:00Z in milliseconds
+const april1 = "1711929600000"
+
+func TestBinanceProvider_GetPrices(t *testing.T) {
+	var requested []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		requested = append(requested, query.Get("symbol"))
+		assert.Equal(t, "1d", query.Get("interval"))
+		switch query.Get("symbol") {
+		case "ETHUSDT":
+			assert.Equal(t, april1, query.Get("startTime"))
+			w.Write([]byte(`[[` + april1 + `, "3500.10", "3600", "3400", "3550", "1000", 1712015999999]]`))
+		case "BTCUSDT":
+			// not traded on the day, the next kline is returned
+			w.Write([]byte(`[[1712016000000, "70000", "71000", "69000", "70500", "10", 1712102399999]]`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"code": -1121, "msg": "Invalid symbol."}`))
+		}
+	}))
+	defer mockServer.Close()
+
+	provider := NewBinanceProvider("")
+	provider.baseUrl = mockServer.URL
+
+	unknown := models.PriceKey{Symbol: "NOPE", Date: "2024-04-01"}
+	prices, err := provider.GetPrices(context.TODO(), []models.PriceKey{
+		ethKey, btcKey, unknown, {Symbol: "NOPE", Date: "2024-04-02"},
+		// a coin pinned in the configuration is still priced by its symbol, a token contract is not
+		{Symbol: "ETH", Date: "2024-04-01", CoinID: "ethereum"},
+		{Symbol: "ETH", Date: "2024-04-01", Chain: "base", Contract: "0x4200000000000000000000000000000000000006"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{ethKey: 3500.10, {Symbol: "ETH", Date: "2024-04-01", CoinID: "ethereum"}: 3500.10}, prices)
+	// unlisted pairs are only asked for once
+	assert.Equal(t, []string{"ETHUSDT", "BTCUSDT", "NOPEUSDT", "ETHUSDT"}, requested)
+}
+
+func TestBinanceProvider_ApiError(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("symbol") == "ETHUSDC" {
+			w.Write([]byte(`[[` + april1 + `, "3500", "3600", "3400", "3550", "1000", 1712015999999]]`))
+			return
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer mockServer.Close()
+
+	provider := NewBinanceProvider("usdc")
+	provider.baseUrl = mockServer.URL
+
+	// the prices found before the failure are returned with the error
+	prices, err := provider.GetPrices(context.TODO(), []models.PriceKey{ethKey, btcKey})
+	assert.ErrorContains(t, err, "request failed with status: 429")
+	assert.Equal(t, models.PriceMap{ethKey: 3500}, prices)
+}
+
+func TestBinanceProvider_GetPrices_Intraday(t *testing.T) {
+	// 2024-04-01T10:00:00Z in milliseconds
+	const tenAM = "1711965600000"
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		assert.Equal(t, "1m", query.Get("interval"))
+		assert.Equal(t, tenAM, query.Get("startTime"))
+		w.Write([]byte(`[[` + tenAM + `, "3512.5", "3513", "3510", "3511", "10", 1711965659999]]`))
+	}))
+	defer mockServer.Close()
+
+	provider := NewBinanceProvider("")
+	provider.baseUrl = mockServer.URL
+
+	key := models.PriceKey{Symbol: "ETH", Date: "2024-04-01", Time: 1711965600}
+	prices, err := provider.GetPrices(context.TODO(), []models.PriceKey{key})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{key: 3512.5}, prices)
+}
@@ -0,0 +1,153 @@
+package pricing
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+)
+
+// filePrice is a single entry of a JSON price file
+type filePrice struct {
+	Symbol string  `json:"symbol"`
+	Date   string  `json:"date"`
+	Price  float64 `json:"price"`
+}
+
+// FileProvider is a PriceProvider serving static prices from a CSV or JSON file.
+// CSV files have a symbol,date,price header, JSON files hold an array of {"symbol", "date", "price"} objects.
+// Dates are in the format 2006-01-02, symbols are matched case-insensitively. Keys with a token contract are left out.
+type FileProvider struct {
+	path   string
+	prices models.PriceMap
+}
+
+// NewFileProvider loads the prices of the file at the given path, the format is chosen by the extension.
+func NewFileProvider(path string) (*FileProvider, error) {
+	var entries []filePrice
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		entries, err = readJSONPrices(path)
+	case ".csv":
+		entries, err = readCSVPrices(path)
+	default:
+		return nil, fmt.Errorf("unsupported price file %s, expected .csv or .json", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read price file %s: %v", path, err)
+	}
+
+	prices := make(models.PriceMap, len(entries))
+	for i, entry := range entries {
+		if entry.Symbol == "" {
+			return nil, fmt.Errorf("price file %s: entry %d has no symbol", path, i+1)
+		}
+		if _, err := time.Parse(time.DateOnly, entry.Date); err != nil {
+			return nil, fmt.Errorf("price file %s: entry %d has an invalid date: %v", path, i+1, err)
+		}
+		if !validPrice(entry.Price) {
+			return nil, fmt.Errorf("price file %s: entry %d has a price which is not finite and positive", path, i+1)
+		}
+		prices[models.PriceKey{Symbol: strings.ToUpper(entry.Symbol), Date: entry.Date}] = entry.Price
+	}
+	return &FileProvider{path: path, prices: prices}, nil
+}
+
+// validPrice reports whether the price is finite and positive, ParseFloat accepts NaN and Inf
+func validPrice(price float64) bool {
+	return price > 0 && !math.IsNaN(price) && !math.IsInf(price, 0)
+}
+
+// Name returns the name of the provider
+func (provider *FileProvider) Name() string {
+	return "file"
+}
+
+// GetPrices returns the prices in the file for the requested keys
+func (provider *FileProvider) GetPrices(ctx context.Context, keys []models.PriceKey) (models.PriceMap, error) {
+	prices := make(models.PriceMap)
+	for _, key := range keys {
+		// the file only holds USD prices by symbol, keys with a time get the price of their day
+		if key.Fiat != "" || key.HasContract() {
+			continue
+		}
+		if price, ok := provider.prices[models.PriceKey{Symbol: strings.ToUpper(key.Symbol), Date: key.Date}]; ok {
+			prices[key] = price
+		}
+	}
+	return prices, nil
+}
+
+// readJSONPrices reads the entries of a JSON price file
+func readJSONPrices(path string) ([]filePrice, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []filePrice
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// readCSVPrices reads the entries of a CSV price file
+func readCSVPrices(path string) ([]filePrice, error) {
+	columns, records, err := readCSVColumns(path, "symbol", "date", "price")
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]filePrice, len(records))
+	for i, record := range records {
+		price, err := strconv.ParseFloat(record[columns["price"]], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid price: %v", i+2, err)
+		}
+		entries[i] = filePrice{
+			Symbol: record[columns["symbol"]],
+			Date:   record[columns["date"]],
+			Price:  price,
+		}
+	}
+	return entries, nil
+}
+
+// readCSVColumns reads the records of a CSV file and the indexes of its header columns,
+// failing if any of the required columns is missing
+func readCSVColumns(path string, required ...string) (map[string]int, [][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	csvReader := csv.NewReader(f)
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		columns[strings.TrimSpace(column)] = i
+	}
+	for _, column := range required {
+		if _, ok := columns[column]; !ok {
+			return nil, nil, fmt.Errorf("header is missing the %s column", column)
+		}
+	}
+
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	return columns, records, nil
+}
@@ -0,0 +1,73 @@
+package pricing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// writePriceFile writes the content to a price file with the given name in a temporary directory
+func writePriceFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestFileProvider_CSV(t *testing.T) {
+	path := writePriceFile(t, "prices.csv", "date,symbol,price\n2024-04-01,eth,3000.5\n2024-04-01,BTC,70000\n")
+
+	provider, err := NewFileProvider(path)
+	assert.NoError(t, err)
+
+	// the file only holds USD prices by symbol, a time gets the price of its day
+	ethInEur := models.PriceKey{Symbol: ethKey.Symbol, Date: ethKey.Date, Fiat: "eur"}
+	ethAtTen := models.PriceKey{Symbol: ethKey.Symbol, Date: ethKey.Date, Time: 1711965600}
+	// a coin pinned in the configuration is still priced by its symbol, a token contract is not
+	pinnedEth := models.PriceKey{Symbol: ethKey.Symbol, Date: ethKey.Date, CoinID: "ethereum"}
+	contractEth := models.PriceKey{Symbol: ethKey.Symbol, Date: ethKey.Date, Chain: "base", Contract: "0x4200000000000000000000000000000000000006"}
+	// a contract without a chain is no token contract
+	chainlessEth := models.PriceKey{Symbol: ethKey.Symbol, Date: ethKey.Date, Contract: "0x4200000000000000000000000000000000000006"}
+	prices, err := provider.GetPrices(context.TODO(), []models.PriceKey{ethKey, btcKey, solKey, ethInEur, ethAtTen, pinnedEth, contractEth, chainlessEth})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{ethKey: 3000.5, btcKey: 70000, ethAtTen: 3000.5, pinnedEth: 3000.5, chainlessEth: 3000.5}, prices)
+}
+
+func TestFileProvider_JSON(t *testing.T) {
+	path := writePriceFile(t, "prices.json", `[
+		{"symbol": "ETH", "date": "2024-04-01", "price": 3000.5},
+		{"symbol": "SOL", "date": "2024-04-02", "price": 180}
+	]`)
+
+	provider, err := NewFileProvider(path)
+	assert.NoError(t, err)
+
+	prices, err := provider.GetPrices(context.TODO(), []models.PriceKey{ethKey, solKey})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{ethKey: 3000.5}, prices)
+}
+
+func TestFileProvider_Invalid(t *testing.T) {
+	tests := map[string]struct {
+		name    string
+		content string
+		err     string
+	}{
+		"unsupported extension": {"prices.txt", "", "unsupported price file"},
+		"missing column":        {"prices.csv", "symbol,price\nETH,1\n", "missing the date column"},
+		"invalid price":         {"prices.csv", "symbol,date,price\nETH,2024-04-01,abc\n", "line 2: invalid price"},
+		"invalid date":          {"prices.json", `[{"symbol": "ETH", "date": "01-04-2024", "price": 1}]`, "entry 1 has an invalid date"},
+		"zero price":            {"prices.json", `[{"symbol": "ETH", "date": "2024-04-01", "price": 0}]`, "not finite and positive"},
+		"NaN price":             {"prices.csv", "symbol,date,price\nETH,2024-04-01,NaN\n", "entry 1 has a price which is not finite and positive"},
+		"infinite price":        {"prices.csv", "symbol,date,price\nETH,2024-04-01,+Inf\n", "not finite and positive"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewFileProvider(writePriceFile(t, test.name, test.content))
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+)
+
+// PriceOverride is a price supplied by hand for a currency over a range of days, with the reason and its author
+type PriceOverride struct {
+	Symbol string `json:"symbol"`
+	// From and To are the first and the last day the price applies to, in the format 2006-01-02
+	From  string  `json:"from"`
+	To    string  `json:"to"`
+	Price float64 `json:"price"`
+	// Currency is the fiat currency of the price, e.g. "eur", USD when empty
+	Currency string `json:"currency"`
+	Reason   string `json:"reason"`
+	Author   string `json:"author"`
+}
+
+// String describes the override for the audit log
+func (override PriceOverride) String() string {
+	return fmt.Sprintf("%s at %g %s from %s to %s by %s: %s", override.Symbol, override.Price, strings.ToUpper(overrideCurrency(override.Currency)),
+		override.From, override.To, override.Author, override.Reason)
+}
+
+// overrideCurrency returns the lowercase fiat currency, usd when empty
+func overrideCurrency(fiat string) string {
+	if fiat = strings.ToLower(strings.TrimSpace(fiat)); fiat != "" {
+		return fiat
+	}
+	return "usd"
+}
+
+// overrideKey identifies the overrides of a symbol in a fiat currency
+type overrideKey struct {
+	// symbol is uppercase, currency lowercase
+	symbol   string
+	currency string
+}
+
+// covers reports whether the override applies to the day
+func (override PriceOverride) covers(date string) bool {
+	// dates in the format 2006-01-02 sort chronologically
+	return override.From <= date && date <= override.To
+}
+
+// OverrideProvider is a PriceProvider serving the prices overridden by hand, it comes ahead of every other provider.
+// CSV files have a symbol,from,to,price,reason,author header and an optional currency column, JSON files hold
+// an array of objects with the same keys. Symbols are matched case-insensitively. A price in a fiat currency without
+// an override of its own is left to the other providers.
+type OverrideProvider struct {
+	path string
+	// overrides by symbol and currency, sorted by their first day
+	overrides map[overrideKey][]PriceOverride
+}
+
+// NewOverrideProvider loads the overrides of the file at the given path, the format is chosen by the extension.
+// Every override needs a reason and an author, the ranges of a symbol in a currency must not overlap.
+func NewOverrideProvider(path string) (*OverrideProvider, error) {
+	var entries []PriceOverride
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		entries, err = readJSONOverrides(path)
+	case ".csv":
+		entries, err = readCSVOverrides(path)
+	default:
+		return nil, fmt.Errorf("unsupported price overrides file %s, expected .csv or .json", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read price overrides file %s: %v", path, err)
+	}
+
+	overrides := make(map[overrideKey][]PriceOverride)
+	for i, entry := range entries {
+		if err := validateOverride(entry); err != nil {
+			return nil, fmt.Errorf("price overrides file %s: entry %d %v", path, i+1, err)
+		}
+		key := overrideKey{symbol: strings.ToUpper(entry.Symbol), currency: overrideCurrency(entry.Currency)}
+		overrides[key] = append(overrides[key], entry)
+	}
+	for key, keyOverrides := range overrides {
+		sort.Slice(keyOverrides, func(i, j int) bool { return keyOverrides[i].From < keyOverrides[j].From })
+		for i := 1; i < len(keyOverrides); i++ {
+			if keyOverrides[i].From <= keyOverrides[i-1].To {
+				return nil, fmt.Errorf("price overrides file %s: overlapping overrides of %s in %s from %s and %s", path, key.symbol, strings.ToUpper(key.currency), keyOverrides[i-1].From, keyOverrides[i].From)
+			}
+		}
+	}
+	return &OverrideProvider{path: path, overrides: overrides}, nil
+}
+
+// validateOverride checks that the override is complete
+func validateOverride(override PriceOverride) error {
+	if override.Symbol == "" {
+		return fmt.Errorf("has no symbol")
+	}
+	from, err := time.Parse(time.DateOnly, override.From)
+	if err != nil {
+		return fmt.Errorf("has an invalid from date: %v", err)
+	}
+	to, err := time.Parse(time.DateOnly, override.To)
+	if err != nil {
+		return fmt.Errorf("has an invalid to date: %v", err)
+	}
+	if to.Before(from) {
+		return fmt.Errorf("ends before it starts")
+	}
+	if !validPrice(override.Price) {
+		return fmt.Errorf("has a price which is not finite and positive")
+	}
+	if strings.TrimSpace(override.Reason) == "" || strings.TrimSpace(override.Author) == "" {
+		return fmt.Errorf("needs a reason and an author")
+	}
+	return nil
+}
+
+// Name returns the name of the provider
+func (provider *OverrideProvider) Name() string {
+	return "overrides"
+}
+
+// Override returns the override of the price of the key in its fiat currency, if there is one
+func (provider *OverrideProvider) Override(key models.PriceKey) (PriceOverride, bool) {
+	if provider == nil {
+		return PriceOverride{}, false
+	}
+	for _, override := range provider.overrides[overrideKey{symbol: strings.ToUpper(key.Symbol), currency: overrideCurrency(key.Fiat)}] {
+		if override.covers(key.Date) {
+			return override, true
+		}
+	}
+	return PriceOverride{}, false
+}
+
+// GetPrices returns the overridden prices of the requested keys
+func (provider *OverrideProvider) GetPrices(ctx context.Context, keys []models.PriceKey) (models.PriceMap, error) {
+	prices := make(models.PriceMap)
+	for _, key := range keys {
+		if override, ok := provider.Override(key); ok {
+			prices[key] = override.Price
+		}
+	}
+	return prices, nil
+}
+
+// readJSONOverrides reads the entries of a JSON price overrides file
+func readJSONOverrides(path string) ([]PriceOverride, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []PriceOverride
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// readCSVOverrides reads the entries of a CSV price overrides file
+func readCSVOverrides(path string) ([]PriceOverride, error) {
+	columns, records, err := readCSVColumns(path, "symbol", "from", "to", "price", "reason", "author")
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]PriceOverride, len(records))
+	for i, record := range records {
+		price, err := strconv.ParseFloat(record[columns["price"]], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid price: %v", i+2, err)
+		}
+		entries[i] = PriceOverride{
+			Symbol: record[columns["symbol"]],
+			From:   record[columns["from"]],
+			To:     record[columns["to"]],
+			Price:  price,
+			Reason: record[columns["reason"]],
+			Author: record[columns["author"]],
+		}
+		// the currency column is optional, USD when missing
+		if column, ok := columns["currency"]; ok {
+			entries[i].Currency = record[column]
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,100 @@
+package pricing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverrideProvider_CSV(t *testing.T) {
+	path := writePriceFile(t, "overrides.csv", "symbol,from,to,price,reason,author\n"+
+		"gala,2024-04-01,2024-04-02,0.05,no CoinGecko history before the listing,alice\n"+
+		"GALA,2024-04-05,2024-04-05,0.07,exchange outage,bob\n")
+
+	provider, err := NewOverrideProvider(path)
+	assert.NoError(t, err)
+
+	first := models.PriceKey{Symbol: "GALA", Date: "2024-04-02"}
+	second := models.PriceKey{Symbol: "GALA", Date: "2024-04-05", Time: 1712311200}
+	between := models.PriceKey{Symbol: "GALA", Date: "2024-04-03"}
+	// a USD override does not override the price in EUR
+	inEur := models.PriceKey{Symbol: "GALA", Date: "2024-04-01", Fiat: "eur"}
+	prices, err := provider.GetPrices(context.TODO(), []models.PriceKey{first, second, between, inEur, ethKey})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{first: 0.05, second: 0.07}, prices)
+
+	override, ok := provider.Override(first)
+	assert.True(t, ok)
+	assert.Equal(t, "gala at 0.05 USD from 2024-04-01 to 2024-04-02 by alice: no CoinGecko history before the listing", override.String())
+
+	// a missing overrides file overrides nothing
+	var none *OverrideProvider
+	_, ok = none.Override(first)
+	assert.False(t, ok)
+}
+
+func TestOverrideProvider_Currency(t *testing.T) {
+	path := writePriceFile(t, "overrides.csv", "symbol,from,to,price,currency,reason,author\n"+
+		"GALA,2024-04-01,2024-04-02,0.05,,no history,alice\n"+
+		"GALA,2024-04-01,2024-04-02,0.046,EUR,no history,alice\n")
+
+	provider, err := NewOverrideProvider(path)
+	assert.NoError(t, err)
+
+	inUsd := models.PriceKey{Symbol: "GALA", Date: "2024-04-01"}
+	inEur := models.PriceKey{Symbol: "GALA", Date: "2024-04-01", Fiat: "eur"}
+	// GBP is not overridden and left to the other providers
+	inGbp := models.PriceKey{Symbol: "GALA", Date: "2024-04-01", Fiat: "gbp"}
+	prices, err := provider.GetPrices(context.TODO(), []models.PriceKey{inUsd, inEur, inGbp})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{inUsd: 0.05, inEur: 0.046}, prices)
+
+	override, ok := provider.Override(inEur)
+	assert.True(t, ok)
+	assert.Equal(t, "GALA at 0.046 EUR from 2024-04-01 to 2024-04-02 by alice: no history", override.String())
+
+	// the chain fills the price in GBP from the next provider
+	chain := NewChain(provider, &mockProvider{name: "next", prices: models.PriceMap{inGbp: 0.04}})
+	prices, err = chain.GetPrices(context.TODO(), []models.PriceKey{inUsd, inEur, inGbp})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{inUsd: 0.05, inEur: 0.046, inGbp: 0.04}, prices)
+}
+
+func TestOverrideProvider_JSON(t *testing.T) {
+	path := writePriceFile(t, "overrides.json", `[
+		{"symbol": "GALA", "from": "2024-04-01", "to": "2024-04-30", "price": 0.05, "reason": "no history", "author": "alice"}
+	]`)
+
+	provider, err := NewOverrideProvider(path)
+	assert.NoError(t, err)
+
+	key := models.PriceKey{Symbol: "gala", Date: "2024-04-30"}
+	prices, err := provider.GetPrices(context.TODO(), []models.PriceKey{key})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{key: 0.05}, prices)
+}
+
+func TestOverrideProvider_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"needs a reason and an author": `[{"symbol": "GALA", "from": "2024-04-01", "to": "2024-04-01", "price": 1, "reason": "no history"}]`,
+		"ends before it starts":        `[{"symbol": "GALA", "from": "2024-04-02", "to": "2024-04-01", "price": 1, "reason": "r", "author": "a"}]`,
+		"invalid from date":            `[{"symbol": "GALA", "from": "April 1", "to": "2024-04-01", "price": 1, "reason": "r", "author": "a"}]`,
+		"not finite and positive":      `[{"symbol": "GALA", "from": "2024-04-01", "to": "2024-04-01", "reason": "r", "author": "a"}]`,
+		"overlapping overrides of GALA": `[
+			{"symbol": "GALA", "from": "2024-04-01", "to": "2024-04-10", "price": 1, "reason": "r", "author": "a"},
+			{"symbol": "gala", "from": "2024-04-10", "to": "2024-04-20", "price": 2, "reason": "r", "author": "b"}
+		]`,
+	}
+	for message, content := range tests {
+		_, err := NewOverrideProvider(writePriceFile(t, "overrides.json", content))
+		assert.ErrorContains(t, err, message)
+	}
+
+	_, err := NewOverrideProvider(writePriceFile(t, "overrides.csv", "symbol,from,to,price,reason,author\nGALA,2024-04-01,2024-04-01,NaN,r,a\n"))
+	assert.ErrorContains(t, err, "entry 1 has a price which is not finite and positive")
+
+	_, err = NewOverrideProvider(writePriceFile(t, "overrides.csv", "symbol,from,to,price\n"))
+	assert.ErrorContains(t, err, "header is missing the reason column")
+}
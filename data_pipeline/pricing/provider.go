@@ -0,0 +1,138 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+)
+
+// PriceProvider prices currencies in USD on given days
+type PriceProvider interface {
+	// Name identifies the provider in logs and errors
+	Name() string
+	// GetPrices returns the prices of the requested keys. Keys the provider has no price for are left out
+	// of the result. On error the prices found so far are returned alongside it.
+	GetPrices(ctx context.Context, keys []models.PriceKey) (models.PriceMap, error)
+}
+
+// ProvenanceProvider is implemented by the providers which know where each price of their last call of GetPrices
+// came from, beyond their own name
+type ProvenanceProvider interface {
+	Provenance() map[models.PriceKey]models.PriceProvenance
+}
+
+// ProviderReport describes what a provider of a Chain contributed to the last call
+type ProviderReport struct {
+	Name string
+	// Requested is the number of keys asked from the provider, Served the number it priced
+	Requested int
+	Served    int
+	// Err is the error the provider failed with, if any
+	Err error
+}
+
+// Chain is a PriceProvider asking its providers in order, each one only for the prices
+// still missing, so a missing or throttled price from one provider is filled by the next.
+type Chain struct {
+	providers  []PriceProvider
+	report     []ProviderReport
+	provenance map[models.PriceKey]models.PriceProvenance
+}
+
+// NewChain creates a new Chain of the given providers, in order of preference.
+func NewChain(providers ...PriceProvider) *Chain {
+	return &Chain{providers: providers}
+}
+
+// Name returns the names of the providers in the chain
+func (chain *Chain) Name() string {
+	names := make([]string, len(chain.providers))
+	for i, provider := range chain.providers {
+		names[i] = provider.Name()
+	}
+	return strings.Join(names, " > ")
+}
+
+// GetPrices asks every provider in turn for the prices not found so far.
+// The errors of the providers are only returned if some prices are still missing in the end.
+func (chain *Chain) GetPrices(ctx context.Context, keys []models.PriceKey) (models.PriceMap, error) {
+	prices := make(models.PriceMap, len(keys))
+	remaining := keys
+	var errs []error
+	chain.report = nil
+	chain.provenance = make(map[models.PriceKey]models.PriceProvenance, len(keys))
+
+	for _, provider := range chain.providers {
+		if len(remaining) == 0 {
+			break
+		}
+
+		found, err := provider.GetPrices(ctx, remaining)
+		fetchedAt := time.Now().UTC()
+		var details map[models.PriceKey]models.PriceProvenance
+		if reporter, ok := provider.(ProvenanceProvider); ok {
+			details = reporter.Provenance()
+		}
+		report := ProviderReport{Name: provider.Name(), Requested: len(remaining), Err: err}
+		var missing []models.PriceKey
+		for _, key := range remaining {
+			if price, ok := found[key]; ok {
+				prices[key] = price
+				report.Served++
+				source := details[key]
+				if source.Provider == "" {
+					source.Provider = provider.Name()
+				}
+				// the fetch time of a cache hit is the one of its entry
+				if source.FetchedAt.IsZero() && !source.CacheHit {
+					source.FetchedAt = fetchedAt
+				}
+				chain.provenance[key] = source
+			} else {
+				missing = append(missing, key)
+			}
+		}
+		chain.report = append(chain.report, report)
+		remaining = missing
+
+		if err != nil {
+			// the next provider would not get any further
+			if ctx.Err() != nil {
+				return prices, ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("%s: %v", provider.Name(), err))
+		}
+	}
+
+	if len(remaining) > 0 && len(errs) > 0 {
+		return prices, errors.Join(errs...)
+	}
+	return prices, nil
+}
+
+// Report returns what every provider asked during the last call of GetPrices contributed
+func (chain *Chain) Report() []ProviderReport {
+	return chain.report
+}
+
+// Provenance returns the provider, and the details it knows, of every price of the last call of GetPrices
+func (chain *Chain) Provenance() map[models.PriceKey]models.PriceProvenance {
+	return chain.provenance
+}
+
+// PricesUsed returns the prices of the keys found in the last call of GetPrices with their provenance, in the order of the keys
+func (chain *Chain) PricesUsed(runID string, keys []models.PriceKey, prices models.PriceMap) []models.PriceUsed {
+	var used []models.PriceUsed
+	for _, key := range keys {
+		price, ok := prices[key]
+		if !ok {
+			continue
+		}
+		used = append(used, models.PriceUsed{RunID: runID, Key: key, Price: price, PriceProvenance: chain.provenance[key]})
+	}
+	return used
+}
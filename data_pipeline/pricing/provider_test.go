@@ -0,0 +1,150 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockProvider serves fixed prices and records the keys it was asked for
+type mockProvider struct {
+	name      string
+	prices    models.PriceMap
+	err       error
+	requested []models.PriceKey
+}
+
+func (provider *mockProvider) Name() string {
+	return provider.name
+}
+
+func (provider *mockProvider) GetPrices(ctx context.Context, keys []models.PriceKey) (models.PriceMap, error) {
+	provider.requested = append(provider.requested, keys...)
+	prices := make(models.PriceMap)
+	for _, key := range keys {
+		if price, ok := provider.prices[key]; ok {
+			prices[key] = price
+		}
+	}
+	return prices, provider.err
+}
+
+var (
+	ethKey = models.PriceKey{Symbol: "ETH", Date: "2024-04-01"}
+	btcKey = models.PriceKey{Symbol: "BTC", Date: "2024-04-01"}
+	solKey = models.PriceKey{Symbol: "SOL", Date: "2024-04-01"}
+)
+
+func TestChain_FillsMissingPrices(t *testing.T) {
+	first := &mockProvider{name: "first", prices: models.PriceMap{ethKey: 3000}}
+	second := &mockProvider{name: "second", prices: models.PriceMap{ethKey: 1, btcKey: 70000}}
+	third := &mockProvider{name: "third", prices: models.PriceMap{solKey: 180}}
+	chain := NewChain(first, second, third)
+	assert.Equal(t, "first > second > third", chain.Name())
+
+	prices, err := chain.GetPrices(context.TODO(), []models.PriceKey{ethKey, btcKey, solKey})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{ethKey: 3000, btcKey: 70000, solKey: 180}, prices)
+
+	// every provider is only asked for what is still missing
+	assert.Equal(t, []models.PriceKey{ethKey, btcKey, solKey}, first.requested)
+	assert.Equal(t, []models.PriceKey{btcKey, solKey}, second.requested)
+	assert.Equal(t, []models.PriceKey{solKey}, third.requested)
+	assert.Equal(t, []ProviderReport{
+		{Name: "first", Requested: 3, Served: 1},
+		{Name: "second", Requested: 2, Served: 1},
+		{Name: "third", Requested: 1, Served: 1},
+	}, chain.Report())
+}
+
+func TestChain_StopsWhenComplete(t *testing.T) {
+	first := &mockProvider{name: "first", prices: models.PriceMap{ethKey: 3000}}
+	second := &mockProvider{name: "second"}
+
+	prices, err := NewChain(first, second).GetPrices(context.TODO(), []models.PriceKey{ethKey})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{ethKey: 3000}, prices)
+	assert.Empty(t, second.requested)
+}
+
+func TestChain_ThrottledProviderFilledByNext(t *testing.T) {
+	throttled := &mockProvider{name: "throttled", prices: models.PriceMap{ethKey: 3000}, err: errors.New("request failed with status: 429 Too Many Requests")}
+	fallback := &mockProvider{name: "fallback", prices: models.PriceMap{btcKey: 70000}}
+	chain := NewChain(throttled, fallback)
+
+	prices, err := chain.GetPrices(context.TODO(), []models.PriceKey{ethKey, btcKey})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{ethKey: 3000, btcKey: 70000}, prices)
+	assert.ErrorContains(t, chain.Report()[0].Err, "429")
+}
+
+func TestChain_ErrorsWhenPricesMissing(t *testing.T) {
+	throttled := &mockProvider{name: "throttled", err: errors.New("request failed with status: 429 Too Many Requests")}
+	fallback := &mockProvider{name: "fallback", prices: models.PriceMap{btcKey: 70000}}
+
+	prices, err := NewChain(throttled, fallback).GetPrices(context.TODO(), []models.PriceKey{ethKey, btcKey})
+	assert.ErrorContains(t, err, "throttled: request failed with status: 429")
+	assert.Equal(t, models.PriceMap{btcKey: 70000}, prices)
+
+	// prices no provider knows are not an error of the chain
+	prices, err = NewChain(fallback).GetPrices(context.TODO(), []models.PriceKey{ethKey, btcKey})
+	assert.NoError(t, err)
+	assert.Equal(t, models.PriceMap{btcKey: 70000}, prices)
+}
+
+func TestChain_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	first := &mockProvider{name: "first", err: context.Canceled}
+	second := &mockProvider{name: "second", prices: models.PriceMap{ethKey: 3000}}
+
+	_, err := NewChain(first, second).GetPrices(ctx, []models.PriceKey{ethKey})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, second.requested)
+}
+
+// provenanceProvider is a mockProvider which knows where its prices came from
+type provenanceProvider struct {
+	mockProvider
+	provenance map[models.PriceKey]models.PriceProvenance
+}
+
+func (provider *provenanceProvider) Provenance() map[models.PriceKey]models.PriceProvenance {
+	return provider.provenance
+}
+
+func TestChain_Provenance(t *testing.T) {
+	fetchedAt := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	first := &provenanceProvider{
+		mockProvider: mockProvider{name: "first", prices: models.PriceMap{ethKey: 3000}},
+		provenance: map[models.PriceKey]models.PriceProvenance{
+			ethKey: {CoinID: "ethereum", FetchedAt: fetchedAt, CacheHit: true},
+		},
+	}
+	second := &mockProvider{name: "second", prices: models.PriceMap{btcKey: 70000}}
+	chain := NewChain(first, second)
+
+	before := time.Now().Add(-time.Second)
+	prices, err := chain.GetPrices(context.TODO(), []models.PriceKey{ethKey, btcKey, solKey})
+	assert.NoError(t, err)
+
+	// the details of the provider are kept, the others are filled by the chain
+	used := chain.PricesUsed("run-1", []models.PriceKey{ethKey, btcKey, solKey}, prices)
+	assert.Len(t, used, 2)
+	assert.Equal(t, models.PriceUsed{
+		RunID:           "run-1",
+		Key:             ethKey,
+		Price:           3000,
+		PriceProvenance: models.PriceProvenance{Provider: "first", CoinID: "ethereum", FetchedAt: fetchedAt, CacheHit: true},
+	}, used[0])
+	assert.Equal(t, "run-1", used[1].RunID)
+	assert.Equal(t, btcKey, used[1].Key)
+	assert.Equal(t, "second", used[1].Provider)
+	assert.Empty(t, used[1].CoinID)
+	assert.False(t, used[1].CacheHit)
+	assert.WithinRange(t, used[1].FetchedAt, before, time.Now())
+}
@@ -0,0 +1,234 @@
+package pricing
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+)
+
+// SanityPolicy decides what happens to prices failing a sanity check
+type SanityPolicy string
+
+const (
+	// SanityReject aborts the run, listing every suspicious price
+	SanityReject SanityPolicy = "reject"
+	// SanityQuarantine drops the suspicious prices and the transactions priced by them from the aggregation,
+	// so the prices can be reviewed
+	SanityQuarantine SanityPolicy = "quarantine"
+)
+
+// Validate checks that the policy is known, an empty policy is SanityReject
+func (policy SanityPolicy) Validate() error {
+	switch policy {
+	case "", SanityReject, SanityQuarantine:
+		return nil
+	default:
+		return fmt.Errorf("unknown price sanity policy %q, expected reject or quarantine", policy)
+	}
+}
+
+// Apply applies the policy to the suspicious prices. It returns the keys of the prices which have to be dropped,
+// or an error listing all of them under the reject policy.
+func (policy SanityPolicy) Apply(suspicious []models.SuspiciousPrice) ([]models.PriceKey, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	if len(suspicious) == 0 {
+		return nil, nil
+	}
+
+	if policy == SanityQuarantine {
+		keys := make([]models.PriceKey, len(suspicious))
+		for i, s := range suspicious {
+			keys[i] = s.Key
+		}
+		return keys, nil
+	}
+	descriptions := make([]string, len(suspicious))
+	for i, s := range suspicious {
+		descriptions[i] = DescribeSuspicious(s)
+	}
+	return nil, fmt.Errorf("suspicious prices: %s", strings.Join(descriptions, ", "))
+}
+
+// DescribeSuspicious describes the suspicious price for logs
+func DescribeSuspicious(suspicious models.SuspiciousPrice) string {
+	key := suspicious.Key
+	when := key.Date
+	if key.Time != 0 {
+		when = time.Unix(key.Time, 0).UTC().Format(time.RFC3339)
+	}
+	fiat := "USD"
+	if key.Fiat != "" {
+		fiat = strings.ToUpper(key.Fiat)
+	}
+	return fmt.Sprintf("%s on %s at %g %s: %s", key.Symbol, when, suspicious.Price, fiat, suspicious.Reason)
+}
+
+// SanityBounds are the limits a price is flagged beyond
+type SanityBounds struct {
+	// MaxDailyMove is the largest factor a price may move by from the previous day, e.g. 3 flags a price
+	// tripling or dropping to a third. No check when zero.
+	MaxDailyMove float64
+	// MaxDeviation is the largest relative deviation from the price of the reference provider, e.g. 0.1.
+	// No check when zero.
+	MaxDeviation float64
+}
+
+// SanityChecker flags prices which moved too far from the previous day or deviate too far from a second provider,
+// e.g. a spike of an illiquid market
+type SanityChecker struct {
+	bounds SanityBounds
+	// reference is the second provider prices are compared with, no comparison when nil
+	reference PriceProvider
+}
+
+// NewSanityChecker creates a new SanityChecker, the reference provider is only needed to check MaxDeviation
+func NewSanityChecker(bounds SanityBounds, reference PriceProvider) (*SanityChecker, error) {
+	if bounds.MaxDailyMove != 0 && bounds.MaxDailyMove <= 1 {
+		return nil, fmt.Errorf("max daily price move must be above 1, got %g", bounds.MaxDailyMove)
+	}
+	if bounds.MaxDeviation < 0 {
+		return nil, fmt.Errorf("max price deviation must not be negative, got %g", bounds.MaxDeviation)
+	}
+	if bounds.MaxDeviation > 0 && reference == nil {
+		return nil, fmt.Errorf("max price deviation needs a reference provider")
+	}
+	return &SanityChecker{bounds: bounds, reference: reference}, nil
+}
+
+// previousKey returns the key of the price of the day before
+func previousKey(key models.PriceKey) (models.PriceKey, error) {
+	date, err := time.Parse(time.DateOnly, key.Date)
+	if err != nil {
+		return models.PriceKey{}, fmt.Errorf("invalid price date %q for %s: %v", key.Date, key.Symbol, err)
+	}
+	previous := key
+	previous.Date = date.AddDate(0, 0, -1).Format(time.DateOnly)
+	if key.Time != 0 {
+		previous.Time = key.Time - int64(24*time.Hour/time.Second)
+	}
+	return previous, nil
+}
+
+// PreviousKeys returns the keys of the previous day prices the keys are compared with which are not keys themselves,
+// to be priced ahead of the check. Keys pinned to a coin ID or a contract are left out, not every provider prices them
+// by their coin, so their first day is not compared with the day before.
+func (checker *SanityChecker) PreviousKeys(keys []models.PriceKey) ([]models.PriceKey, error) {
+	if checker == nil || checker.bounds.MaxDailyMove == 0 {
+		return nil, nil
+	}
+	requested := make(map[models.PriceKey]bool, len(keys))
+	for _, key := range keys {
+		requested[key] = true
+	}
+	var previousKeys []models.PriceKey
+	for _, key := range keys {
+		if key.CoinID != "" || key.HasContract() {
+			continue
+		}
+		previous, err := previousKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if !requested[previous] {
+			requested[previous] = true
+			previousKeys = append(previousKeys, previous)
+		}
+	}
+	return previousKeys, nil
+}
+
+// Check returns the suspicious prices of the keys, in chronological order. The prices hold the prices of the keys
+// and of their PreviousKeys; a previous day without a price is not compared with. A price is compared with the last
+// price of its currency which passed, so the day after a spike is not flagged as well. On error of the reference
+// provider the suspicious prices found without it are returned alongside the error.
+func (checker *SanityChecker) Check(ctx context.Context, keys []models.PriceKey, prices models.PriceMap) ([]models.SuspiciousPrice, error) {
+	if checker == nil {
+		return nil, nil
+	}
+	var checked []models.PriceKey
+	for _, key := range keys {
+		if _, ok := prices[key]; ok {
+			checked = append(checked, key)
+		}
+	}
+	sort.SliceStable(checked, func(i, j int) bool {
+		if checked[i].Date != checked[j].Date {
+			return checked[i].Date < checked[j].Date
+		}
+		return checked[i].Time < checked[j].Time
+	})
+
+	var references models.PriceMap
+	var referenceErr error
+	if checker.bounds.MaxDeviation > 0 {
+		references, referenceErr = checker.reference.GetPrices(ctx, checked)
+		if referenceErr != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			referenceErr = fmt.Errorf("reference provider %s: %v", checker.reference.Name(), referenceErr)
+		}
+	}
+
+	var suspicious []models.SuspiciousPrice
+	// the price every checked key passes on to the next day, zero when it has none
+	passed := make(models.PriceMap, len(checked))
+	for _, key := range checked {
+		price := prices[key]
+		last := checker.lastPrice(key, prices, passed)
+		flagged := checker.flag(key, price, last, references)
+		if flagged == nil {
+			passed[key] = price
+			continue
+		}
+		suspicious = append(suspicious, *flagged)
+		// the next day is compared with the last price which passed
+		passed[key] = last
+	}
+	return suspicious, referenceErr
+}
+
+// lastPrice returns the price of the day before the key which passed, zero when there is none
+func (checker *SanityChecker) lastPrice(key models.PriceKey, prices, passed models.PriceMap) float64 {
+	if checker.bounds.MaxDailyMove == 0 {
+		return 0
+	}
+	previous, err := previousKey(key)
+	if err != nil {
+		return 0
+	}
+	if last, ok := passed[previous]; ok {
+		return last
+	}
+	return max(prices[previous], 0)
+}
+
+// flag returns the suspicious price if the price of the key fails a check, nil if it passes.
+// The price is compared with the last price of the day before unless it is zero.
+func (checker *SanityChecker) flag(key models.PriceKey, price, last float64, references models.PriceMap) *models.SuspiciousPrice {
+	if price <= 0 {
+		return &models.SuspiciousPrice{Key: key, Price: price, Reason: "non-positive price"}
+	}
+
+	if last > 0 {
+		if move := math.Max(price/last, last/price); move > checker.bounds.MaxDailyMove {
+			reason := fmt.Sprintf("moved by a factor of %.2f from %g the day before", move, last)
+			return &models.SuspiciousPrice{Key: key, Price: price, Reference: last, Reason: reason}
+		}
+	}
+
+	if reference, ok := references[key]; ok && reference > 0 {
+		if deviation := math.Abs(price-reference) / reference; deviation > checker.bounds.MaxDeviation {
+			reason := fmt.Sprintf("%.2f%% off the %g of %s", 100*deviation, reference, checker.reference.Name())
+			return &models.SuspiciousPrice{Key: key, Price: price, Reference: reference, Reason: reason}
+		}
+	}
+	return nil
+}
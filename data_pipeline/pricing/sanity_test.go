@@ -0,0 +1,119 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// galaKey returns the key of the daily GALA price on the day of April 2024
+func galaKey(day int) models.PriceKey {
+	return models.PriceKey{Symbol: "GALA", Date: time.Date(2024, 4, day, 0, 0, 0, 0, time.UTC).Format(time.DateOnly)}
+}
+
+func TestSanityChecker_PreviousKeys(t *testing.T) {
+	checker, err := NewSanityChecker(SanityBounds{MaxDailyMove: 3}, nil)
+	assert.NoError(t, err)
+
+	ten := time.Date(2024, 4, 2, 10, 0, 0, 0, time.UTC).Unix()
+	previousKeys, err := checker.PreviousKeys([]models.PriceKey{
+		galaKey(1), galaKey(2),
+		{Symbol: "ETH", Date: "2024-04-02", Time: ten, Fiat: "eur"},
+		// pinned coins could be priced by another coin of their symbol
+		{Symbol: "GALA", Date: "2024-04-05", CoinID: "gala-v1"},
+		{Symbol: "USDC", Date: "2024-04-05", Chain: "base", Contract: "0x833589fcd6edb6e08f4c7c32d4f71b54bda02913"},
+	})
+	assert.NoError(t, err)
+	// the previous days already asked for are left out
+	assert.Equal(t, []models.PriceKey{
+		galaKey(0),
+		{Symbol: "ETH", Date: "2024-04-01", Time: ten - 24*60*60, Fiat: "eur"},
+	}, previousKeys)
+
+	// no previous day is needed without the check
+	var disabled *SanityChecker
+	previousKeys, err = disabled.PreviousKeys([]models.PriceKey{galaKey(1)})
+	assert.NoError(t, err)
+	assert.Empty(t, previousKeys)
+}
+
+func TestSanityChecker_DailyMove(t *testing.T) {
+	checker, err := NewSanityChecker(SanityBounds{MaxDailyMove: 3}, nil)
+	assert.NoError(t, err)
+
+	keys := []models.PriceKey{galaKey(5), galaKey(4), galaKey(3), galaKey(2), galaKey(1)}
+	prices := models.PriceMap{
+		// the day before the first day is priced too
+		galaKey(0): 0.05,
+		galaKey(1): 0.04,
+		// a spike, the day after it is compared with the day before it
+		galaKey(2): 4,
+		galaKey(3): 0.05,
+		galaKey(4): 0,
+		galaKey(5): 0.2,
+	}
+	suspicious, err := checker.Check(context.TODO(), keys, prices)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.SuspiciousPrice{
+		{Key: galaKey(2), Price: 4, Reference: 0.04, Reason: "moved by a factor of 100.00 from 0.04 the day before"},
+		{Key: galaKey(4), Price: 0, Reason: "non-positive price"},
+		{Key: galaKey(5), Price: 0.2, Reference: 0.05, Reason: "moved by a factor of 4.00 from 0.05 the day before"},
+	}, suspicious)
+	assert.Equal(t, "GALA on 2024-04-02 at 4 USD: moved by a factor of 100.00 from 0.04 the day before", DescribeSuspicious(suspicious[0]))
+}
+
+func TestSanityChecker_Reference(t *testing.T) {
+	reference := &mockProvider{name: "binance", prices: models.PriceMap{ethKey: 3000, btcKey: 70000}}
+	checker, err := NewSanityChecker(SanityBounds{MaxDeviation: 0.1}, reference)
+	assert.NoError(t, err)
+
+	suspicious, err := checker.Check(context.TODO(), []models.PriceKey{ethKey, btcKey, solKey}, models.PriceMap{
+		ethKey: 3200,
+		btcKey: 700000,
+		// prices the reference does not know pass
+		solKey: 180,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.SuspiciousPrice{
+		{Key: btcKey, Price: 700000, Reference: 70000, Reason: "900.00% off the 70000 of binance"},
+	}, suspicious)
+
+	// a failing reference is reported with the prices it flagged so far
+	reference.err = errors.New("request failed with status: 429 Too Many Requests")
+	suspicious, err = checker.Check(context.TODO(), []models.PriceKey{btcKey}, models.PriceMap{btcKey: 700000})
+	assert.ErrorContains(t, err, "reference provider binance: request failed with status: 429")
+	assert.Len(t, suspicious, 1)
+}
+
+func TestNewSanityChecker_Invalid(t *testing.T) {
+	_, err := NewSanityChecker(SanityBounds{MaxDailyMove: 0.5}, nil)
+	assert.ErrorContains(t, err, "max daily price move must be above 1")
+
+	_, err = NewSanityChecker(SanityBounds{MaxDeviation: 0.1}, nil)
+	assert.ErrorContains(t, err, "needs a reference provider")
+}
+
+func TestSanityPolicy_Apply(t *testing.T) {
+	suspicious := []models.SuspiciousPrice{
+		{Key: galaKey(2), Price: 4, Reference: 0.04, Reason: "moved by a factor of 100.00 from 0.04 the day before"},
+	}
+
+	_, err := SanityReject.Apply(suspicious)
+	assert.ErrorContains(t, err, "suspicious prices: GALA on 2024-04-02 at 4 USD")
+	_, err = SanityPolicy("").Apply(suspicious)
+	assert.Error(t, err)
+
+	keys, err := SanityQuarantine.Apply(suspicious)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.PriceKey{galaKey(2)}, keys)
+
+	keys, err = SanityReject.Apply(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	assert.Error(t, SanityPolicy("warn").Validate())
+}
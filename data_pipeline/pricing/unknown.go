@@ -0,0 +1,72 @@
+package pricing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// UnknownSymbolPolicy decides what happens to currency symbols the primary provider does not know
+type UnknownSymbolPolicy string
+
+const (
+	// UnknownFail aborts the run, listing every unknown symbol
+	UnknownFail UnknownSymbolPolicy = "fail"
+	// UnknownSkip drops the transactions in unknown symbols from the aggregation
+	UnknownSkip UnknownSymbolPolicy = "skip"
+	// UnknownFallback leaves unknown symbols to the next providers of the chain
+	UnknownFallback UnknownSymbolPolicy = "fallback"
+)
+
+// UnknownSymbol is a currency symbol without a price source and the transactions it affects
+type UnknownSymbol struct {
+	Symbol          string
+	NumTransactions uint64
+	// TotalValue is the volume in units of the currency
+	TotalValue  decimal.Decimal
+	NumProjects int
+}
+
+// String describes the unknown symbol and its impact for logs
+func (unknown UnknownSymbol) String() string {
+	return fmt.Sprintf("%s (%d transactions, volume %s, %d projects)", unknown.Symbol, unknown.NumTransactions, unknown.TotalValue, unknown.NumProjects)
+}
+
+// Validate checks that the policy is known, an empty policy is UnknownFail
+func (policy UnknownSymbolPolicy) Validate() error {
+	switch policy {
+	case "", UnknownFail, UnknownSkip, UnknownFallback:
+		return nil
+	default:
+		return fmt.Errorf("unknown symbol policy %q, expected fail, skip or fallback", policy)
+	}
+}
+
+// Apply applies the policy to the unknown symbols. It returns the symbols whose transactions
+// have to be dropped, or an error listing all of them under the fail policy.
+func (policy UnknownSymbolPolicy) Apply(unknown []UnknownSymbol) ([]string, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	if len(unknown) == 0 {
+		return nil, nil
+	}
+
+	switch policy {
+	case UnknownSkip:
+		symbols := make([]string, len(unknown))
+		for i, u := range unknown {
+			symbols[i] = u.Symbol
+		}
+		return symbols, nil
+	case UnknownFallback:
+		return nil, nil
+	default:
+		descriptions := make([]string, len(unknown))
+		for i, u := range unknown {
+			descriptions[i] = u.String()
+		}
+		return nil, fmt.Errorf("unknown currency symbols: %s", strings.Join(descriptions, ", "))
+	}
+}
@@ -0,0 +1,43 @@
+package pricing
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+var unknownSymbols = []UnknownSymbol{
+	{Symbol: "FOO", NumTransactions: 3, TotalValue: decimal.RequireFromString("12.5"), NumProjects: 2},
+	{Symbol: "BAR", NumTransactions: 1, TotalValue: decimal.NewFromInt(1), NumProjects: 1},
+}
+
+func TestUnknownSymbolPolicy_Fail(t *testing.T) {
+	for _, policy := range []UnknownSymbolPolicy{"", UnknownFail} {
+		_, err := policy.Apply(unknownSymbols)
+		// every unknown symbol is reported at once, with the transactions it affects
+		assert.EqualError(t, err, "unknown currency symbols: FOO (3 transactions, volume 12.5, 2 projects), BAR (1 transactions, volume 1, 1 projects)")
+	}
+
+	excluded, err := UnknownFail.Apply(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, excluded)
+}
+
+func TestUnknownSymbolPolicy_Skip(t *testing.T) {
+	excluded, err := UnknownSkip.Apply(unknownSymbols)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"FOO", "BAR"}, excluded)
+}
+
+func TestUnknownSymbolPolicy_Fallback(t *testing.T) {
+	excluded, err := UnknownFallback.Apply(unknownSymbols)
+	assert.NoError(t, err)
+	assert.Empty(t, excluded)
+}
+
+func TestUnknownSymbolPolicy_Invalid(t *testing.T) {
+	assert.ErrorContains(t, UnknownSymbolPolicy("ignore").Validate(), `unknown symbol policy "ignore"`)
+	_, err := UnknownSymbolPolicy("ignore").Apply(unknownSymbols)
+	assert.Error(t, err)
+}
@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"fmt"
 	"log"
 	"os"
+	"slices"
+	"strings"
 	"time"
+	// embedded time zone database, so zones load on hosts without one
+	_ "time/tzdata"
 
 	"cloud.google.com/go/storage"
 	"github.com/0xivanov/blockchain-data-aggregator/config"
@@ -12,10 +18,15 @@ import (
 	coingecko "github.com/0xivanov/blockchain-data-aggregator/data_pipeline/coin_gecko"
 	"github.com/0xivanov/blockchain-data-aggregator/data_pipeline/db"
 	"github.com/0xivanov/blockchain-data-aggregator/data_pipeline/extraction"
+	"github.com/0xivanov/blockchain-data-aggregator/data_pipeline/pricing"
+	"github.com/0xivanov/blockchain-data-aggregator/models"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/option"
 )
 
+// rejectBatchSize is the number of rejected rows saved to ClickHouse at once
+const rejectBatchSize = 10000
+
 func main() {
 	// Load the configuration from config.json
 	config, err := config.LoadConfig("config.json")
@@ -23,9 +34,34 @@ func main() {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
 
-	// Create the context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	// No overall deadline, rate limited fetches, backoff and cache warming can take far longer than a fixed timeout
+	ctx := context.Background()
+
+	// The arguments select the command, the aggregation runs when there are none
+	command := strings.Join(os.Args[1:], " ")
+	switch command {
+	case "", "run":
+		runAggregation(ctx, config)
+	case "cache warm":
+		warmPriceCache(ctx, config)
+	case "cache purge":
+		purgePriceCache(config)
+	case "cache stats":
+		printPriceCacheStats(config)
+	case "tokens refresh":
+		refreshTokenList(ctx, config)
+	default:
+		log.Fatalf("Unknown command %q, expected run, cache warm, cache purge, cache stats or tokens refresh", command)
+	}
+}
+
+// runAggregation extracts the transactions, prices and aggregates them and saves the result into ClickHouse
+func runAggregation(ctx context.Context, config *config.Config) {
+	runID, err := newRunID()
+	if err != nil {
+		log.Fatalf("Failed to create run ID: %v", err)
+	}
+	log.Printf("Starting run %s", runID)
 
 	// Initialize the ClickHouse database
 	db, err := db.NewClickHouseDB(config.ClickhouseDSN, config.DbName)
@@ -33,50 +69,630 @@ func main() {
 		log.Fatalf("Failed to initialize ClickHouse: %v", err)
 	}
 
-	// Initialize the CoinGecko client
-	geckoClient := coingecko.NewCoinGeckoClient(config.CoinGeckoAPI, "coingecko_token_api_list.csv")
-
-	// Load the service account credentials from the JSON key file
-	credentials, err := os.ReadFile(config.BucketKeyPath)
+	// Initialize the price providers and the price cache
+	priceCache, err := openPriceCache(config)
+	if err != nil {
+		log.Fatalf("Failed to open price cache: %v", err)
+	}
+	if priceCache != nil {
+		defer priceCache.Close()
+	}
+	overrides, err := newPriceOverrides(config)
 	if err != nil {
-		log.Fatalf("Failed to read service account key file: %v", err)
+		log.Fatalf("Failed to load price overrides: %v", err)
+	}
+	priceProvider, geckoClient, err := newPriceProvider(config, priceCache, overrides)
+	if err != nil {
+		log.Fatalf("Failed to create price providers: %v", err)
 	}
 
-	// Initialize the GCP extractor
-	gcpConf, err := google.CredentialsFromJSON(ctx, credentials, storage.ScopeReadOnly)
+	unknownSymbolPolicy := pricing.UnknownSymbolPolicy(config.UnknownSymbolPolicy)
+	if err := unknownSymbolPolicy.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	sanityChecker, err := newSanityChecker(config, priceCache)
 	if err != nil {
-		log.Fatalf("Failed to create credentials from JSON: %v", err)
+		log.Fatalf("Failed to create price sanity checks: %v", err)
+	}
+	sanityPolicy := pricing.SanityPolicy(config.PriceSanity.Policy)
+	if err := sanityPolicy.Validate(); err != nil {
+		log.Fatal(err)
 	}
-	client, err := storage.NewClient(ctx, option.WithCredentials(gcpConf))
+
+	// Initialize the sink for rows rejected under the quarantine policy, either
+	// a dead-letter file or the rejected_rows table in batches
+	tableRejects, err := extraction.NewBatchRejectSink(rejectBatchSize, func(rows []models.RejectedRow) error {
+		return db.SaveRejectedRows(ctx, rows)
+	})
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		log.Fatalf("Failed to initialize rejected rows batches: %v", err)
+	}
+	var fileRejects *extraction.FileRejectSink
+	var rejects extraction.RejectSink = tableRejects
+	if extraction.ErrorPolicy(config.ErrorPolicy) == extraction.Quarantine && config.DeadLetterPath != "" {
+		fileRejects, err = extraction.NewFileRejectSink(config.DeadLetterPath)
+		if err != nil {
+			log.Fatalf("Failed to initialize dead-letter file: %v", err)
+		}
+		rejects = fileRejects
 	}
-	defer client.Close()
-	gcpExtractor := extraction.NewGCPExtractor(client)
 
-	// Download the transactions from GCS
-	transactions, err := gcpExtractor.ExtractTransactionsFromGCS(config.BucketName, config.ObjectName, ctx)
+	aggregator, err := extractTransactions(ctx, config, rejects)
+	// flush the dead-letter file and the last rejected rows even if the extraction failed, they explain why
+	if fileRejects != nil {
+		if err := fileRejects.Close(); err != nil {
+			log.Printf("Failed to write dead-letter file: %v", err)
+		}
+	}
+	flushErr := tableRejects.Flush()
 	if err != nil {
-		log.Fatalf("Failed to fetch data from GCS: %v", err)
+		log.Fatal(err)
+	}
+	if flushErr != nil {
+		log.Fatalf("Failed to save rejected rows into ClickHouse: %v", flushErr)
+	}
+
+	// Report the symbols CoinGecko does not know before any request is sent
+	if err := checkUnknownSymbols(ctx, geckoClient, aggregator, overrides, unknownSymbolPolicy); err != nil {
+		log.Fatal(err)
 	}
-	log.Println("Data successfully extracted from GCS")
 
-	// Get the prices from CoinGecko
-	priceMap, err := geckoClient.GetPriceMap(ctx, transactions)
+	// Get the prices from the providers, one per currency and reporting day
+	priceKeys := aggregator.PriceKeys()
+	previousPrices, err := fetchPreviousPrices(ctx, sanityChecker, priceProvider, priceKeys)
+	if err != nil {
+		log.Fatalf("Failed to get the prices of the previous days: %v", err)
+	}
+	priceMap, err := priceProvider.GetPrices(ctx, priceKeys)
+	for _, report := range priceProvider.Report() {
+		if report.Err != nil {
+			log.Printf("Price provider %s priced %d of %d prices and failed: %v", report.Name, report.Served, report.Requested, report.Err)
+		} else {
+			log.Printf("Price provider %s priced %d of %d prices", report.Name, report.Served, report.Requested)
+		}
+	}
 	if err != nil {
 		log.Fatalf("Failed to get price map: %v", err)
 	}
-	log.Println("Prices successfully fetched from CoinGecko")
+	log.Printf("%d of %d prices successfully fetched", len(priceMap), len(priceKeys))
+	logPriceCacheStats(priceCache)
+	logAmbiguousSymbols(geckoClient)
+	logDepegs(geckoClient)
+	markOverriddenPrices(aggregator, overrides, priceKeys)
+
+	// Check the prices before they are used, suspicious ones fail the run or are quarantined
+	quarantined, err := checkPriceSanity(ctx, sanityChecker, sanityPolicy, aggregator, overrides, priceKeys, priceMap, previousPrices)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Aggregate the transactions
-	marketplaceData, err := aggregate.AggregateTransactions(transactions, priceMap)
+	marketplaceData, err := aggregator.Result(priceMap)
 	if err != nil {
 		log.Fatalf("Failed to aggregate transactions: %v", err)
 	}
 
 	// Save the aggregated data into ClickHouse
-	if err := db.SaveMarketplaceData(ctx, marketplaceData); err != nil {
+	if err := db.SaveMarketplaceData(ctx, runID, marketplaceData); err != nil {
 		log.Fatalf("Failed to save data into ClickHouse: %v", err)
 	}
 	log.Println("Data successfully inserted into ClickHouse")
+
+	// Save the prices the aggregates were computed with, so they can be audited and recomputed
+	if err := db.SavePricesUsed(ctx, priceProvider.PricesUsed(runID, priceKeys, priceMap)); err != nil {
+		log.Fatalf("Failed to save the prices used into ClickHouse: %v", err)
+	}
+	if err := db.SaveQuarantinedPrices(ctx, runID, quarantined); err != nil {
+		log.Fatalf("Failed to save the quarantined prices into ClickHouse: %v", err)
+	}
+}
+
+// newRunID returns a unique ID of the run, the start time followed by a random suffix
+func newRunID() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%x", time.Now().UTC().Format("20060102T150405Z"), suffix), nil
+}
+
+// warmPriceCache fetches every price needed by the configured source into the price cache,
+// without touching ClickHouse. Rejected rows are dropped.
+func warmPriceCache(ctx context.Context, config *config.Config) {
+	priceCache, err := openPriceCache(config)
+	if err != nil {
+		log.Fatalf("Failed to open price cache: %v", err)
+	}
+	if priceCache == nil {
+		log.Fatal("No price cache configured, set priceCachePath")
+	}
+	defer priceCache.Close()
+
+	geckoClient, err := newCoinGeckoClient(config, priceCache)
+	if err != nil {
+		log.Fatalf("Failed to create CoinGecko client: %v", err)
+	}
+
+	// the rejected rows are saved by the aggregation runs, warming only needs the transactions
+	aggregator, err := extractTransactions(ctx, config, extraction.DiscardRejectSink{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	priceKeys := aggregator.PriceKeys()
+	if _, err := geckoClient.GetPrices(ctx, priceKeys); err != nil {
+		log.Fatalf("Failed to warm price cache: %v", err)
+	}
+	log.Printf("Price cache warmed with %d prices", len(priceKeys))
+	logPriceCacheStats(priceCache)
+	logAmbiguousSymbols(geckoClient)
+}
+
+// purgePriceCache removes every cached price
+func purgePriceCache(config *config.Config) {
+	priceCache, err := openPriceCache(config)
+	if err != nil {
+		log.Fatalf("Failed to open price cache: %v", err)
+	}
+	if priceCache == nil {
+		log.Fatal("No price cache configured, set priceCachePath")
+	}
+	defer priceCache.Close()
+
+	n, err := priceCache.Purge()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Removed %d prices from %s", n, config.PriceCachePath)
+}
+
+// printPriceCacheStats logs the number of cached prices
+func printPriceCacheStats(config *config.Config) {
+	priceCache, err := openPriceCache(config)
+	if err != nil {
+		log.Fatalf("Failed to open price cache: %v", err)
+	}
+	if priceCache == nil {
+		log.Fatal("No price cache configured, set priceCachePath")
+	}
+	defer priceCache.Close()
+
+	n, err := priceCache.Len()
+	if err != nil {
+		log.Fatalf("Failed to read price cache: %v", err)
+	}
+	log.Printf("%s holds %d prices", config.PriceCachePath, n)
+}
+
+// refreshTokenList downloads the CoinGecko token list into a new version in the token list directory
+// and logs how it differs from the previous version
+func refreshTokenList(ctx context.Context, config *config.Config) {
+	if config.TokenListDir == "" {
+		log.Fatal("No token list directory configured, set tokenListDir")
+	}
+	geckoClient, err := newCoinGeckoClient(config, nil)
+	if err != nil {
+		log.Fatalf("Failed to create CoinGecko client: %v", err)
+	}
+
+	path, diff, err := geckoClient.RefreshTokenList(ctx, config.TokenListDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch {
+	case diff.Previous == "":
+		log.Printf("Token list downloaded to %s: %d coins", path, len(diff.Added))
+	case diff.Empty():
+		log.Printf("Token list unchanged since %s", diff.Previous)
+	default:
+		log.Printf("Token list downloaded to %s: %d coins added, %d removed, %d changed since %s",
+			path, len(diff.Added), len(diff.Removed), len(diff.Changed), diff.Previous)
+		logTokenListChanges("Added", diff.Added)
+		logTokenListChanges("Removed", diff.Removed)
+		logTokenListChanges("Changed", diff.Changed)
+	}
+}
+
+// logTokenListChanges logs the IDs of the changed coins, shortened to the first few
+func logTokenListChanges(change string, ids []string) {
+	const shown = 20
+	if len(ids) == 0 {
+		return
+	}
+	if len(ids) > shown {
+		log.Printf("%s: %s and %d more", change, strings.Join(ids[:shown], ", "), len(ids)-shown)
+		return
+	}
+	log.Printf("%s: %s", change, strings.Join(ids, ", "))
+}
+
+// extractTransactions streams the transactions of the configured source into an aggregator
+func extractTransactions(ctx context.Context, config *config.Config, rejects extraction.RejectSink) (*aggregate.Aggregator, error) {
+	// Initialize the configured transaction source
+	parser, err := newParser(config, rejects)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize parser: %v", err)
+	}
+	source, closeSource, err := newSource(ctx, config, parser)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize %s source: %v", config.Source, err)
+	}
+	defer closeSource()
+
+	// Stream the transactions from the source into the aggregator
+	reportingLocation, err := time.LoadLocation(config.ReportingTimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reporting time zone: %v", err)
+	}
+	resolution, err := priceResolution(config)
+	if err != nil {
+		return nil, err
+	}
+	aggregator := aggregate.NewAggregator(reportingLocation)
+	aggregator.SetResolution(resolution)
+	aggregator.SetFiatCurrencies(config.FiatCurrencies...)
+	coinOverrides := coingecko.CoinOverrides{Symbols: config.CoinIDs.Symbols, Projects: config.CoinIDs.Projects}
+	err = source.StreamTransactions(ctx, func(txn models.Transaction) error {
+		// pin ambiguous symbols to the coin configured for the project
+		txn.CoinID = coinOverrides.CoinID(txn.ProjectID, txn.CurrencySymbol)
+		aggregator.Add(txn)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch data from %s: %v", config.Source, err)
+	}
+	// rejected records do not count, a run without a single valid one is an error. A single empty object of
+	// several is fine.
+	accepted, rejected := parser.Counts()
+	if accepted == 0 {
+		return nil, fmt.Errorf("no transactions found in %s: %d rows rejected", config.Source, rejected)
+	}
+	log.Printf("Data successfully extracted from %s: %d rows accepted, %d rows rejected", config.Source, accepted, rejected)
+	return aggregator, nil
+}
+
+// openPriceCache opens the price cache in the configuration, it returns nil when none is configured
+func openPriceCache(config *config.Config) (*coingecko.PriceCache, error) {
+	if config.PriceCachePath == "" {
+		return nil, nil
+	}
+	return coingecko.OpenPriceCache(config.PriceCachePath)
+}
+
+// checkUnknownSymbols collects the currency symbols missing from the CoinGecko token list, logs the transactions
+// they affect and applies the policy: fail, drop their transactions or leave them to the next price providers.
+// Token contracts unknown to CoinGecko are priced by their symbol. Prices overridden by hand need no symbol.
+func checkUnknownSymbols(ctx context.Context, geckoClient *coingecko.CoinGeckoClient, aggregator *aggregate.Aggregator, overrides *pricing.OverrideProvider, policy pricing.UnknownSymbolPolicy) error {
+	if geckoClient == nil {
+		return nil
+	}
+	var keys []models.PriceKey
+	for _, key := range aggregator.PriceKeys() {
+		if _, ok := overrides.Override(key); !ok {
+			keys = append(keys, key)
+		}
+	}
+	symbols, contracts, err := geckoClient.UnknownSymbols(ctx, keys)
+	if err != nil {
+		return err
+	}
+	for _, contract := range contracts {
+		log.Printf("Warning: unknown token contract %s on %s, pricing it by its symbol %s", contract.Contract, contract.Chain, contract.Symbol)
+	}
+	aggregator.Unpin(contracts...)
+
+	totals := aggregator.CurrencyTotals()
+	unknown := make([]pricing.UnknownSymbol, len(symbols))
+	for i, symbol := range symbols {
+		unknown[i] = pricing.UnknownSymbol{
+			Symbol:          symbol,
+			NumTransactions: totals[symbol].NumTransactions,
+			TotalValue:      totals[symbol].TotalValue,
+			NumProjects:     totals[symbol].NumProjects,
+		}
+	}
+
+	excluded, err := policy.Apply(unknown)
+	if err != nil {
+		return err
+	}
+	for _, u := range unknown {
+		switch {
+		case len(excluded) > 0:
+			log.Printf("Warning: skipping unknown currency symbol %s", u)
+		default:
+			log.Printf("Warning: unknown currency symbol %s, falling back to the next price providers", u)
+		}
+	}
+	aggregator.Exclude(excluded...)
+	return nil
+}
+
+// fetchPreviousPrices gets the prices of the days before the keys the sanity checks compare them with.
+// A missing price only skips the comparison, so the errors of the providers are logged.
+func fetchPreviousPrices(ctx context.Context, checker *pricing.SanityChecker, provider pricing.PriceProvider, keys []models.PriceKey) (models.PriceMap, error) {
+	previousKeys, err := checker.PreviousKeys(keys)
+	if err != nil || len(previousKeys) == 0 {
+		return nil, err
+	}
+	prices, err := provider.GetPrices(ctx, previousKeys)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("Warning: %d of %d prices of the previous days fetched: %v", len(prices), len(previousKeys), err)
+	}
+	return prices, nil
+}
+
+// checkPriceSanity flags the suspicious prices, logs them and applies the policy: fail, or drop the prices and the
+// transactions converted with them. The quarantined prices are returned. Prices overridden by hand are not checked.
+func checkPriceSanity(ctx context.Context, checker *pricing.SanityChecker, policy pricing.SanityPolicy, aggregator *aggregate.Aggregator, overrides *pricing.OverrideProvider, priceKeys []models.PriceKey, priceMap, previousPrices models.PriceMap) ([]models.SuspiciousPrice, error) {
+	if checker == nil {
+		return nil, nil
+	}
+	var keys []models.PriceKey
+	for _, key := range priceKeys {
+		if _, ok := overrides.Override(key); !ok {
+			keys = append(keys, key)
+		}
+	}
+	prices := make(models.PriceMap, len(priceMap)+len(previousPrices))
+	for key, price := range previousPrices {
+		prices[key] = price
+	}
+	for key, price := range priceMap {
+		prices[key] = price
+	}
+
+	suspicious, err := checker.Check(ctx, keys, prices)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		log.Printf("Warning: %v", err)
+	}
+	for _, s := range suspicious {
+		log.Printf("Warning: suspicious price %s", pricing.DescribeSuspicious(s))
+	}
+
+	excluded, err := policy.Apply(suspicious)
+	if err != nil {
+		return nil, err
+	}
+	if len(excluded) == 0 {
+		return nil, nil
+	}
+	for _, key := range excluded {
+		delete(priceMap, key)
+	}
+	aggregator.ExcludePrices(excluded...)
+	log.Printf("%d suspicious prices quarantined, the transactions converted with them are left out", len(excluded))
+	return suspicious, nil
+}
+
+// markOverriddenPrices flags the aggregates computed with prices overridden by hand and logs every override used
+func markOverriddenPrices(aggregator *aggregate.Aggregator, overrides *pricing.OverrideProvider, priceKeys []models.PriceKey) {
+	logged := make(map[string]bool)
+	for _, key := range priceKeys {
+		override, ok := overrides.Override(key)
+		if !ok {
+			continue
+		}
+		aggregator.MarkOverridden(key)
+		if !logged[override.String()] {
+			logged[override.String()] = true
+			log.Printf("Price overridden: %s", override)
+		}
+	}
+}
+
+// logAmbiguousSymbols warns about the currency symbols shared by several CoinGecko coins, if any
+func logAmbiguousSymbols(geckoClient *coingecko.CoinGeckoClient) {
+	if geckoClient == nil {
+		return
+	}
+	for _, ambiguous := range geckoClient.AmbiguousSymbols() {
+		log.Printf("Warning: ambiguous currency symbol %s, pin it in coinIds to choose another coin", ambiguous)
+	}
+}
+
+// logDepegs warns about the stablecoins whose sampled price deviated from their peg
+func logDepegs(geckoClient *coingecko.CoinGeckoClient) {
+	if geckoClient == nil {
+		return
+	}
+	for _, depeg := range geckoClient.Depegs() {
+		log.Printf("Warning: stablecoin %s deviates from its peg, the peg is still used", depeg)
+	}
+}
+
+// logPriceCacheStats logs the hits and misses of the price cache, if any
+func logPriceCacheStats(priceCache *coingecko.PriceCache) {
+	if priceCache == nil {
+		return
+	}
+	stats := priceCache.Stats()
+	log.Printf("Price cache: %d hits, %d misses", stats.Hits, stats.Misses)
+}
+
+// newPriceOverrides loads the prices overridden by hand, it returns nil when no overrides file is configured
+func newPriceOverrides(config *config.Config) (*pricing.OverrideProvider, error) {
+	if config.PriceOverridesPath == "" {
+		return nil, nil
+	}
+	return pricing.NewOverrideProvider(config.PriceOverridesPath)
+}
+
+// newPriceProvider creates the fallback chain of the price providers in the configuration, behind the overrides if any.
+// The CoinGecko client of the chain is returned as well, nil when it is not part of it.
+func newPriceProvider(config *config.Config, priceCache *coingecko.PriceCache, overrides *pricing.OverrideProvider) (*pricing.Chain, *coingecko.CoinGeckoClient, error) {
+	names := priceProviderNames(config)
+
+	var providers []pricing.PriceProvider
+	if overrides != nil {
+		providers = append(providers, overrides)
+	}
+	var geckoClient *coingecko.CoinGeckoClient
+	for _, name := range names {
+		provider, err := newNamedPriceProvider(config, priceCache, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if client, ok := provider.(*coingecko.CoinGeckoClient); ok {
+			geckoClient = client
+		}
+		providers = append(providers, provider)
+	}
+	return pricing.NewChain(providers...), geckoClient, nil
+}
+
+// newNamedPriceProvider creates the price provider of the given name in the configuration
+func newNamedPriceProvider(config *config.Config, priceCache *coingecko.PriceCache, name string) (pricing.PriceProvider, error) {
+	switch name {
+	case "coingecko":
+		return newCoinGeckoClient(config, priceCache)
+	case "file":
+		return pricing.NewFileProvider(config.PriceFilePath)
+	case "binance":
+		return pricing.NewBinanceProvider(config.BinanceQuoteAsset), nil
+	default:
+		return nil, fmt.Errorf("unknown price provider %q", name)
+	}
+}
+
+// priceProviderNames returns the names of the price providers in the configuration, CoinGecko when there are none
+func priceProviderNames(config *config.Config) []string {
+	if len(config.PriceProviders) == 0 {
+		return []string{"coingecko"}
+	}
+	return config.PriceProviders
+}
+
+// priceResolution returns the interval transactions are priced at. Minutes are refused with CoinGecko among the price
+// providers, it only serves hourly samples of past days and every minute would be its own group.
+func priceResolution(config *config.Config) (time.Duration, error) {
+	granularity := aggregate.Granularity(config.PriceGranularity)
+	resolution, err := granularity.Resolution()
+	if err != nil {
+		return 0, err
+	}
+	if granularity == aggregate.GranularityMinute && slices.Contains(priceProviderNames(config), "coingecko") {
+		return 0, fmt.Errorf("minute price granularity needs minute prices, the coingecko price provider only serves hourly samples")
+	}
+	return resolution, nil
+}
+
+// newSanityChecker creates the sanity checks of the prices in the configuration, it returns nil when no bound is set.
+// The reference provider must not be part of the chain: it would compare the prices with themselves and,
+// for CoinGecko, a second client would double the request rate of the API key.
+func newSanityChecker(config *config.Config, priceCache *coingecko.PriceCache) (*pricing.SanityChecker, error) {
+	sanity := config.PriceSanity
+	if sanity.MaxDailyMove == 0 && sanity.MaxDeviation == 0 {
+		return nil, nil
+	}
+	var reference pricing.PriceProvider
+	if sanity.ReferenceProvider != "" {
+		if slices.Contains(priceProviderNames(config), sanity.ReferenceProvider) {
+			return nil, fmt.Errorf("reference provider %q is one of the price providers, prices would be compared with themselves", sanity.ReferenceProvider)
+		}
+		var err error
+		reference, err = newNamedPriceProvider(config, priceCache, sanity.ReferenceProvider)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pricing.NewSanityChecker(pricing.SanityBounds{MaxDailyMove: sanity.MaxDailyMove, MaxDeviation: sanity.MaxDeviation}, reference)
+}
+
+// newCoinGeckoClient creates the CoinGecko client configured for the plan of the API key
+func newCoinGeckoClient(config *config.Config, priceCache *coingecko.PriceCache) (*coingecko.CoinGeckoClient, error) {
+	pegs := make(map[string]coingecko.Peg, len(config.Pegs))
+	for symbol, peg := range config.Pegs {
+		pegs[symbol] = coingecko.Peg{Price: peg.Price, CoinID: peg.CoinID}
+	}
+	return coingecko.NewCoinGeckoClient(config.CoinGeckoAPI, "coingecko_token_api_list.csv", coingecko.ClientOptions{
+		Plan:              coingecko.Plan(config.CoinGeckoPlan),
+		RequestsPerMinute: config.CoinGeckoRequestsPerMinute,
+		Workers:           config.CoinGeckoWorkers,
+		MaxRetries:        config.CoinGeckoMaxRetries,
+		Cache:             priceCache,
+		RankByMarketCap:   config.CoinGeckoRankByMarketCap,
+		TokenListDir:      config.TokenListDir,
+		ChainPlatforms:    config.ChainPlatforms,
+		Pegs:              pegs,
+		DepegThreshold:    config.DepegThreshold,
+		DepegSamples:      config.DepegSamples,
+	})
+}
+
+// newParser creates the parser for the export layout and error policy in the configuration
+func newParser(config *config.Config, rejects extraction.RejectSink) (*extraction.Parser, error) {
+	fields := extraction.DefaultFieldMapping()
+	if config.Fields.Symbol != "" {
+		fields.Symbol = config.Fields.Symbol
+	}
+	if config.Fields.Amount != "" {
+		fields.Amount = config.Fields.Amount
+	}
+	if config.Fields.Chain != "" {
+		fields.Chain = config.Fields.Chain
+	}
+	if config.Fields.Contract != "" {
+		fields.Contract = config.Fields.Contract
+	}
+	fields.Extra = config.Fields.Extra
+
+	// an empty zone name loads UTC
+	sourceLocation, err := time.LoadLocation(config.SourceTimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source time zone: %v", err)
+	}
+
+	return extraction.NewParser(extraction.ParserOptions{
+		Fields:           fields,
+		Columns:          config.Columns,
+		Format:           extraction.Format(config.Format),
+		TimestampLayouts: config.TimestampLayouts,
+		Location:         sourceLocation,
+		ErrorPolicy:      extraction.ErrorPolicy(config.ErrorPolicy),
+		Rejects:          rejects,
+		MaxRejects:       config.MaxRejects,
+	})
+}
+
+// newSource creates the transaction source selected in the configuration.
+// The returned function releases any resources held by the source.
+func newSource(ctx context.Context, config *config.Config, parser *extraction.Parser) (extraction.Source, func(), error) {
+	switch config.Source {
+	case "gcs":
+		// Load the service account credentials from the JSON key file
+		credentials, err := os.ReadFile(config.BucketKeyPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read service account key file: %v", err)
+		}
+
+		gcpConf, err := google.CredentialsFromJSON(ctx, credentials, storage.ScopeReadOnly)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create credentials from JSON: %v", err)
+		}
+		client, err := storage.NewClient(ctx, option.WithCredentials(gcpConf))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create client: %v", err)
+		}
+		return extraction.NewGCPExtractor(client, parser, config.BucketName, config.ObjectName, config.ExtractWorkers), func() { client.Close() }, nil
+	case "local":
+		return extraction.NewLocalExtractor(parser, config.LocalPath), func() {}, nil
+	case "s3":
+		return extraction.NewS3Extractor(parser, extraction.S3Options{
+			Endpoint:   config.S3Endpoint,
+			Region:     config.S3Region,
+			AccessKey:  config.S3AccessKey,
+			SecretKey:  config.S3SecretKey,
+			BucketName: config.BucketName,
+			ObjectName: config.ObjectName,
+		}), func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown source %q", config.Source)
+	}
 }
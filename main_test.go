@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0xivanov/blockchain-data-aggregator/config"
+	"github.com/0xivanov/blockchain-data-aggregator/data_pipeline/extraction"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSanityChecker_ExampleConfig(t *testing.T) {
+	exampleConfig, err := config.LoadConfig("config.example.json")
+	assert.NoError(t, err)
+
+	checker, err := newSanityChecker(exampleConfig, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, checker)
+
+	// the reference provider must not be part of the chain
+	exampleConfig.PriceProviders = append(exampleConfig.PriceProviders, exampleConfig.PriceSanity.ReferenceProvider)
+	_, err = newSanityChecker(exampleConfig, nil)
+	assert.ErrorContains(t, err, "is one of the price providers")
+}
+
+func TestExtractTransactions_AllRejected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.csv")
+	csvContent := `ts,project_id,props,nums
+not a timestamp,project_1,"{""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""1""}"
+`
+	assert.NoError(t, os.WriteFile(path, []byte(csvContent), 0o644))
+
+	runConfig := &config.Config{Source: "local", LocalPath: path, ErrorPolicy: "skip"}
+	_, err := extractTransactions(context.TODO(), runConfig, &extraction.MemoryRejectSink{})
+	assert.ErrorContains(t, err, "no transactions found in local: 1 rows rejected")
+}
+
+func TestPriceResolution(t *testing.T) {
+	resolution, err := priceResolution(&config.Config{PriceGranularity: "hourly"})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, resolution)
+
+	// CoinGecko, the default provider, has no minute prices
+	_, err = priceResolution(&config.Config{PriceGranularity: "minute"})
+	assert.ErrorContains(t, err, "coingecko price provider only serves hourly samples")
+	_, err = priceResolution(&config.Config{PriceGranularity: "minute", PriceProviders: []string{"file", "coingecko"}})
+	assert.Error(t, err)
+
+	resolution, err = priceResolution(&config.Config{PriceGranularity: "minute", PriceProviders: []string{"binance"}})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, resolution)
+}
@@ -1,13 +1,77 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
 
 // The aggregated data for a single day and project
 type MarketplaceData struct {
 	Date            string
 	ProjectID       string
 	NumTransactions uint64
-	TotalVolumeUSD  float64
+	// TotalVolumeUSD is exact, prices are taken at their shortest decimal representation
+	TotalVolumeUSD decimal.Decimal
+	// Volumes holds the total volume in every additional fiat currency, keyed by lowercase currency code
+	Volumes map[string]decimal.Decimal
+	// PriceOverridden is set when any of the prices the volume was computed with was overridden by hand
+	PriceOverridden bool
+}
+
+// PriceKey identifies the price of a currency on a single day
+type PriceKey struct {
+	Symbol string
+	// Date is the day in 2006-01-02 format
+	Date string
+	// Time is the Unix time of the intraday price, zero for the price of the day
+	Time int64
+	// CoinID pins the CoinGecko coin of the symbol, empty to resolve the symbol
+	CoinID string
+	// Chain and Contract identify the token by its contract address on a network, empty to resolve the symbol
+	Chain    string
+	Contract string
+	// Fiat is the lowercase code of the fiat currency of the price, e.g. "eur", empty for USD
+	Fiat string
+}
+
+// HasContract reports whether the key identifies its currency by a token contract, a contract without a chain
+// does not identify anything
+func (key PriceKey) HasContract() bool {
+	return key.Chain != "" && key.Contract != ""
+}
+
+// PriceMap holds the fiat prices of currencies by day
+type PriceMap map[PriceKey]float64
+
+// PriceProvenance records where a price came from
+type PriceProvenance struct {
+	// Provider names the price provider which served the price
+	Provider string
+	// CoinID is the coin the price was looked up by, empty for providers without coin IDs
+	CoinID string
+	// FetchedAt is when the price was fetched from its source, zero when unknown
+	FetchedAt time.Time
+	// CacheHit is set when the price was served from the price cache instead of the source
+	CacheHit bool
+}
+
+// PriceUsed is a price an aggregate was computed with and its provenance, recorded per run so the
+// aggregates can be audited and recomputed
+type PriceUsed struct {
+	RunID string
+	Key   PriceKey
+	Price float64
+	PriceProvenance
+}
+
+// SuspiciousPrice is a price which failed a sanity check
+type SuspiciousPrice struct {
+	Key   PriceKey
+	Price float64
+	// Reference is the price it was compared with, zero when it failed on its own
+	Reference float64
+	Reason    string
 }
 
 // A single transaction record
@@ -15,5 +79,21 @@ type Transaction struct {
 	Date                 time.Time
 	ProjectID            string
 	CurrencySymbol       string
-	CurrencyValueDecimal float64
+	CurrencyValueDecimal decimal.Decimal
+	// CoinID pins the CoinGecko coin of the currency when the symbol is ambiguous, empty to resolve the symbol
+	CoinID string
+	// Chain is the network the transaction happened on and ContractAddress the token contract of the currency, both optional
+	Chain           string
+	ContractAddress string
+	// Extra holds the additional fields captured from the export, keyed by their configured name
+	Extra map[string]string
+}
+
+// A raw record that could not be turned into a transaction
+type RejectedRow struct {
+	// Source names the file or object the record was read from
+	Source string
+	Line   int
+	Reason string
+	Record string
 }
@@ -4,7 +4,75 @@ CREATE TABLE IF NOT EXISTS blockchainAggregator.marketplace_data (
   date Date,
   project_id String,
   num_transactions Int32,
-  total_volume_usd Float32
+  total_volume_usd Decimal(38, 18),
+  price_overridden Bool DEFAULT false,
+  run_id String DEFAULT ''
 ) ENGINE = MergeTree()
 PARTITION BY toYYYYMM(date)
-ORDER BY (date, project_id);
\ No newline at end of file
+ORDER BY (date, project_id);
+
+-- tables created before manual price overrides lack the audit flag
+ALTER TABLE blockchainAggregator.marketplace_data ADD COLUMN IF NOT EXISTS price_overridden Bool DEFAULT false;
+
+-- volumes are exact decimals, tables created before stored them as floats
+ALTER TABLE blockchainAggregator.marketplace_data MODIFY COLUMN total_volume_usd Decimal(38, 18);
+
+-- tables created before run IDs cannot be joined with the prices used
+ALTER TABLE blockchainAggregator.marketplace_data ADD COLUMN IF NOT EXISTS run_id String DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS blockchainAggregator.marketplace_volumes (
+  date Date,
+  project_id String,
+  currency String,
+  total_volume Decimal(38, 18),
+  run_id String DEFAULT ''
+) ENGINE = MergeTree()
+PARTITION BY toYYYYMM(date)
+ORDER BY (date, project_id, currency);
+
+ALTER TABLE blockchainAggregator.marketplace_volumes MODIFY COLUMN total_volume Decimal(38, 18);
+ALTER TABLE blockchainAggregator.marketplace_volumes ADD COLUMN IF NOT EXISTS run_id String DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS blockchainAggregator.rejected_rows (
+  source String,
+  line UInt64,
+  reason String,
+  record String,
+  rejected_at DateTime DEFAULT now()
+) ENGINE = MergeTree()
+PARTITION BY toYYYYMM(rejected_at)
+ORDER BY (rejected_at, source, line);
+
+CREATE TABLE IF NOT EXISTS blockchainAggregator.prices_used (
+  run_id String,
+  symbol String,
+  coin_id String,
+  chain String,
+  contract String,
+  currency String,
+  date Date,
+  time Nullable(DateTime),
+  price Float64,
+  provider String,
+  fetched_at Nullable(DateTime),
+  cache_hit Bool
+) ENGINE = MergeTree()
+PARTITION BY toYYYYMM(date)
+ORDER BY (run_id, date, symbol);
+
+CREATE TABLE IF NOT EXISTS blockchainAggregator.quarantined_prices (
+  run_id String,
+  symbol String,
+  coin_id String,
+  chain String,
+  contract String,
+  currency String,
+  date Date,
+  time Nullable(DateTime),
+  price Float64,
+  reference_price Float64,
+  reason String,
+  quarantined_at DateTime DEFAULT now()
+) ENGINE = MergeTree()
+PARTITION BY toYYYYMM(date)
+ORDER BY (run_id, date, symbol);
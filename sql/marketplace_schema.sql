@@ -2,7 +2,9 @@ CREATE TABLE marketplace_data (
     date Date,
     project_id String,
     num_transactions Int32,
-    total_volume_usd Float32
+    total_volume_usd Decimal(38, 18),
+    price_overridden Bool DEFAULT false,
+    run_id String DEFAULT ''
 ) ENGINE = MergeTree()
 PARTITION BY toYYYYMM(date)
 ORDER BY (date, project_id);